@@ -2,151 +2,394 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
-)
 
-// PingResult represents the results of a website health check
-type PingResult struct {
-	Status  string `json:"status"`
-	Loss    string `json:"loss"`
-	AvgTime string `json:"avg_time"`
-	Error   string `json:"error,omitempty"`
-}
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
-// WebsiteMonitor manages website health checking
-type WebsiteMonitor struct {
-	websites []string
-	results  map[string]PingResult
-	mu       sync.RWMutex
-}
+	"ping/monitor"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "Address the API is served on, behind the IP filter, rate limit and API token")
+	internalListen := flag.String("internal-listen", "", "Additional address serving the full API without IP filtering, rate limiting or token checks, for trusted internal networks (empty disables)")
+	apiToken := flag.String("api-token", os.Getenv("MONITOR_API_TOKEN"), "Bearer token required by mutating endpoints (defaults to $MONITOR_API_TOKEN)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "Grace period for in-flight requests and checks on shutdown")
+	allowTestAlert := flag.Bool("allow-test-alert", false, "Enable POST /test-alert/{host} to send synthetic alerts")
+	proxyUser := flag.String("proxy-user", os.Getenv("MONITOR_PROXY_USER"), "Username for authenticating forward proxies (defaults to $MONITOR_PROXY_USER; the password is read from $MONITOR_PROXY_PASSWORD)")
+	timezone := flag.String("timezone", "UTC", "IANA timezone used for timestamps in human-facing output such as /ping.txt")
+	waitInitial := flag.Bool("wait-initial", false, "Finish one check of every site before serving the API, so /ping never answers without results")
+	selfTestURL := flag.String("self-test-url", monitor.DefaultSelfTestURL, "URL checked on startup to verify outbound connectivity (empty disables)")
+	selfTestInterval := flag.Duration("self-test-interval", time.Minute, "How often the self-test is re-run after startup (0 runs it only once)")
+	configPath := flag.String("config", "", "Path to a JSON config file listing the sites to monitor")
+	allowCIDRs := flag.String("allow-cidrs", "", "Comma-separated CIDRs allowed to access the API (empty allows all)")
+	denyCIDRs := flag.String("deny-cidrs", "", "Comma-separated CIDRs denied access to the API")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated CIDRs of proxies whose X-Forwarded-For header is trusted")
+	filterExempt := flag.String("ip-filter-exempt", "/healthz", "Comma-separated paths exempt from IP filtering")
+	rateLimit := flag.Float64("rate-limit", 0, "Maximum API requests per second (0 disables rate limiting)")
+	rateBurst := flag.Int("rate-burst", 10, "Burst size for API rate limiting")
+	rateExempt := flag.String("rate-limit-exempt", "/healthz", "Comma-separated paths exempt from rate limiting")
+	logBodyOnFailure := flag.Bool("log-body-on-failure", false, "Log the response body of failed checks")
+	failureBodyBytes := flag.Int("failure-body-bytes", 512, "Maximum number of body bytes captured for failed checks")
+	failureBodyInResult := flag.Bool("failure-body-in-result", false, "Include the captured failure body in the result error")
+	redactHeaders := flag.String("redact-headers", strings.Join(monitor.DefaultRedactedHeaders, ","), "Comma-separated response headers whose values are redacted wherever headers are captured")
+	// Regular expressions may contain commas, so each pattern gets its own flag
+	var redactPatterns []string
+	flag.Func("redact-patterns", "Regular expression redacted from captured bodies (repeatable)", func(pattern string) error {
+		redactPatterns = append(redactPatterns, pattern)
+		return nil
+	})
+	sourceIP := flag.String("source-ip", "", "Local IP address checks are sent from")
+	maxClockSkew := flag.Duration("max-clock-skew", 30*time.Second, "How far a server's Date header may differ from local time before results are flagged (0 disables)")
+	retries := flag.Int("retries", 0, "Additional attempts for checks failing with retryable errors")
+	totalTimeout := flag.Duration("total-timeout", 5*time.Second, "Default ceiling for a whole check, including retries and the delays between them")
+	requestTimeout := flag.Duration("request-timeout", 0, "Default limit for a single HTTP attempt within -total-timeout (0 lets an attempt use all the time left)")
+	retryDelay := flag.Duration("retry-delay", time.Second, "Delay between check attempts")
+	alertWebhook := flag.String("alert-webhook", "", "URL alerts are POSTed to as JSON (alerts are logged when empty)")
+	alertAttempts := flag.Int("alert-attempts", 5, "Maximum delivery attempts per webhook alert")
+	alertBackoff := flag.Duration("alert-backoff", time.Second, "Initial delay between webhook alert retries, doubled each attempt")
+	alertBatch := flag.Duration("alert-batch-window", 0, "Window in which alerts are collected into one notification (0 sends each alert on its own)")
+	quietHours := flag.String("quiet-hours", "", "Daily window, e.g. 22:00-07:00, in which only alerts as urgent as -quiet-hours-severity are sent and the rest are held for a digest")
+	quietHoursSeverity := flag.String("quiet-hours-severity", monitor.SeverityCritical, "Least urgent alert severity (critical, warning or info) still sent during -quiet-hours")
+	alertBatchBypass := flag.String("alert-batch-bypass", "", "Least urgent alert severity (critical, warning or info) sent straight away instead of batched (empty batches every alert)")
+	quietHoursTimezone := flag.String("quiet-hours-timezone", "", "IANA timezone of -quiet-hours (defaults to -timezone)")
+	alertDedupKey := flag.String("alert-dedup-key", "", "Template for alert dedup keys using {{.Site}} and {{.Host}} (defaults to the site URL)")
+	alertDeadLetter := flag.String("alert-dead-letter", "", "File undeliverable alerts are appended to as JSON lines (logged only when empty)")
+	historySize := flag.Int("history-size", 1000, "Number of check samples kept per site")
+	statusHistorySize := flag.Int("status-history-size", 0, "Number of check outcomes kept per site for uptime (0 uses -history-size)")
+	latencyHistorySize := flag.Int("latency-history-size", 0, "Number of successful check latencies kept per site for percentiles (0 uses -history-size)")
+	maxSamples := flag.Int("max-samples", 0, "Maximum history samples held across all sites, oldest evicted first (0 is unlimited)")
+	historyRetention := flag.Duration("history-retention", 30*24*time.Hour, "Maximum age of samples accepted by history import")
+	sloTarget := flag.Float64("slo-target", 0, "Availability objective for burn-rate alerting, e.g. 0.999 (0 disables)")
+	burnShort := flag.Duration("burn-short-window", 5*time.Minute, "Short burn-rate window")
+	burnLong := flag.Duration("burn-long-window", time.Hour, "Long burn-rate window")
+	burnThreshold := flag.Float64("burn-rate-threshold", 14.4, "Burn rate both windows must exceed to alert")
+	histogramBuckets := flag.String("histogram-buckets", "", "Comma-separated response time histogram buckets in seconds")
+	statsdAddr := flag.String("statsd-addr", "", "StatsD host:port results are sent to over UDP after each check cycle")
+	statsdPrefix := flag.String("statsd-prefix", "monitor.", "Prefix for StatsD metric names")
+	statsdTags := flag.Bool("statsd-tags", true, "Tag StatsD metrics DogStatsD-style; when false the site is put in the metric name")
+	influxURL := flag.String("influx-url", "", "InfluxDB write URL results are sent to as line protocol after each check cycle, e.g. http://influx:8086/api/v2/write?org=ops&bucket=monitor")
+	influxToken := flag.String("influx-token", os.Getenv("MONITOR_INFLUX_TOKEN"), "InfluxDB API token (defaults to $MONITOR_INFLUX_TOKEN)")
+	selfTimeout := flag.Duration("self-timeout", 2*time.Second, "Timeout for sites pointing back at the monitor's own listen address (0 uses -total-timeout)")
+	selfAlerts := flag.Bool("self-alerts", false, "Raise alerts for sites pointing back at the monitor's own listen address")
+	pushURL := flag.String("push-url", "", "URL the results are POSTed to as JSON after each check cycle")
+	pushHistory := flag.Int("push-history", 0, "Number of each site's most recent samples included in pushed results (0 sends only the latest results)")
+	allowExec := flag.Bool("allow-exec", false, "Allow exec checks, which run commands from the config")
+	schedule := flag.String("schedule", monitor.ScheduleBatch, "Check scheduling: batch checks all sites at once, spread spaces them evenly across the interval")
+	freshnessWindow := flag.Duration("freshness-window", time.Minute, "Maximum result age served by /ping?fresh=true before checking again")
+	freshTimeout := flag.Duration("fresh-timeout", 8*time.Second, "How long /ping?fresh=true waits for new results")
+	pingCacheTTL := flag.Duration("ping-cache-ttl", time.Second, "How long a serialized /ping response is reused while results are unchanged (0 disables caching)")
+	shuffle := flag.Bool("shuffle", false, "Check sites in a random order each cycle")
+	shuffleSeed := flag.Uint64("shuffle-seed", 0, "Seed for -shuffle (0 picks one at random)")
+	skipOverrun := flag.Bool("skip-overrun-cycles", false, "Skip a scheduled check cycle while the previous one is still running")
+	maxPerHost := flag.Int("max-checks-per-host", 2, "Maximum checks of the same host running at once (0 is unlimited)")
+	maxConcurrent := flag.Int("max-concurrent-checks", 0, "Maximum checks running at once, fastest sites first (0 is unlimited)")
+	overallRed := flag.Int("overall-red-failures", 1, "Number of down sites that turns the overall status red")
+	overallSlow := flag.Duration("overall-slow-latency", 0, "Latency above which a site counts as degraded in the overall status (0 disables)")
+	validate := flag.Bool("validate", false, "Validate the configuration and exit")
+	flag.Parse()
 
-// NewWebsiteMonitor creates a new monitor with the given websites
-func NewWebsiteMonitor(websites []string) *WebsiteMonitor {
-	return &WebsiteMonitor{
-		websites: websites,
-		results:  make(map[string]PingResult),
+	ipFilter, err := NewIPFilter(splitList(*allowCIDRs), splitList(*denyCIDRs),
+		splitList(*trustedProxies), splitList(*filterExempt))
+	if err != nil {
+		log.Fatalf("Invalid IP filter configuration: %v", err)
 	}
-}
 
-// StartMonitoring begins continuous checking of websites
-func (wm *WebsiteMonitor) StartMonitoring(ctx context.Context) {
-	go func() {
-		ticker := time.NewTicker(2 * time.Minute)
-		defer ticker.Stop()
-
-		// Do an initial check of all sites
-		wm.checkAllSites()
-
-		for {
-			select {
-			case <-ticker.C:
-				wm.checkAllSites()
-			case <-ctx.Done():
-				log.Println("Monitoring stopped")
-				return
-			}
+	if *sourceIP != "" {
+		if err := monitor.ValidateSourceIP(*sourceIP); err != nil {
+			log.Fatalf("Invalid -source-ip: %v", err)
 		}
-	}()
-}
+	}
 
-// checkAllSites performs health checks on all configured websites
-func (wm *WebsiteMonitor) checkAllSites() {
-	for _, site := range wm.websites {
-		go func(site string) {
-			log.Printf("Checking %s...", site)
-			result := wm.httpCheck(site)
+	limiter := NewRateLimiter(*rateLimit, *rateBurst, splitList(*rateExempt))
 
-			wm.mu.Lock()
-			wm.results[site] = result
-			wm.mu.Unlock()
+	redact, err := monitor.CompileRedactPatterns(redactPatterns)
+	if err != nil {
+		log.Fatalf("Invalid body logging configuration: %v", err)
+	}
 
-			log.Printf("HTTP check for %s - Status: %s, Loss: %s, Avg time: %s",
-				site, result.Status, result.Loss, result.AvgTime)
-		}(site)
+	buckets, err := monitor.ParseBuckets(splitList(*histogramBuckets))
+	if err != nil {
+		log.Fatalf("Invalid histogram configuration: %v", err)
 	}
-}
 
-// httpCheck performs an HTTP request to check website health
-func (wm *WebsiteMonitor) httpCheck(site string) PingResult {
-	// Make sure the URL has a scheme
-	if !strings.HasPrefix(site, "http://") && !strings.HasPrefix(site, "https://") {
-		site = "https://" + site
+	websites := []monitor.SiteConfig{
+		{URL: "google.com"},
+		{URL: "https://todoappdb-kaushiksahu18.onrender.com/"},
+		{URL: "https://theconnect-fu5n.onrender.com/"},
+		{URL: "https://all-in-one-server-thud.onrender.com/"},
+	}
+	cfg := &monitor.Config{Sites: websites}
+	if *configPath != "" {
+		var err error
+		if cfg, err = monitor.LoadConfig(*configPath); err != nil {
+			log.Fatalf("Invalid config: %v", err)
+		}
+	}
+	if *schedule != monitor.ScheduleBatch && *schedule != monitor.ScheduleSpread {
+		log.Fatalf("Invalid -schedule %q: must be %s or %s", *schedule, monitor.ScheduleBatch, monitor.ScheduleSpread)
+	}
+	if !*allowExec {
+		for _, site := range cfg.AllSites() {
+			if site.Type() == monitor.CheckExec {
+				log.Fatalf("Invalid config: site %s is an exec check, which requires -allow-exec", site.URL)
+			}
+		}
+	}
+	selfAddrs := []string{*listen}
+	if *internalListen != "" {
+		selfAddrs = append(selfAddrs, *internalListen)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// applyTimeouts sets the flags that decide each site's effective timeout
+	applyTimeouts := func(wm *monitor.WebsiteMonitor) {
+		wm.TotalTimeout = *totalTimeout
+		wm.RequestTimeout = *requestTimeout
+		wm.SelfAddrs = selfAddrs
+		wm.SelfTimeout = *selfTimeout
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site, nil)
-	if err != nil {
-		return PingResult{
-			Status: "failed",
-			Loss:   "100%",
-			Error:  fmt.Sprintf("Failed to create request: %v", err),
+	if *validate {
+		total := len(cfg.AllSites())
+		if total == 0 {
+			log.Fatal("Invalid config: no sites configured")
+		}
+		// Some settings are only valid against the timeout flags
+		wm := monitor.NewWebsiteMonitor(cfg.AllSites())
+		applyTimeouts(wm)
+		if err := wm.ValidateSites(); err != nil {
+			log.Fatalf("Invalid config: %v", err)
 		}
+		log.Printf("Config OK: %d site(s)", total)
+		return
 	}
 
-	start := time.Now()
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	duration := time.Since(start)
+	if *internalListen != "" {
+		log.Printf("Starting HTTP check service on %s, with the internal API on %s", *listen, *internalListen)
+	} else {
+		log.Printf("Starting HTTP check service on %s", *listen)
+	}
 
-	if err != nil {
-		return PingResult{
-			Status: "failed",
-			Loss:   "100%",
-			Error:  fmt.Sprintf("Request failed: %v", err),
+	var slo *monitor.SLOConfig
+	if *sloTarget != 0 {
+		slo = &monitor.SLOConfig{
+			Target:      *sloTarget,
+			ShortWindow: *burnShort,
+			LongWindow:  *burnLong,
+			Threshold:   *burnThreshold,
+		}
+		if err := slo.Validate(); err != nil {
+			log.Fatalf("Invalid SLO configuration: %v", err)
 		}
 	}
-	defer resp.Body.Close()
 
-	return PingResult{
-		Status:  "success",
-		Loss:    "0%",
-		AvgTime: fmt.Sprintf("%.2f ms", float64(duration.Milliseconds())),
+	var deadLetter io.Writer
+	if *alertDeadLetter != "" {
+		f, err := os.OpenFile(*alertDeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Fatalf("Failed to open dead-letter log: %v", err)
+		}
+		defer f.Close()
+		deadLetter = f
 	}
-}
 
-// GetResults returns the current monitoring results
-func (wm *WebsiteMonitor) GetResults() map[string]PingResult {
-	wm.mu.RLock()
-	defer wm.mu.RUnlock()
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		log.Fatalf("Invalid timezone: %v", err)
+	}
 
-	// Create a copy to avoid external modification
-	resultsCopy := make(map[string]PingResult, len(wm.results))
-	for k, v := range wm.results {
-		resultsCopy[k] = v
+	if err := monitor.ValidateSeverity(*quietHoursSeverity); err != nil {
+		log.Fatalf("Invalid -quiet-hours-severity: %v", err)
+	}
+	if *alertBatchBypass != "" {
+		if err := monitor.ValidateSeverity(*alertBatchBypass); err != nil {
+			log.Fatalf("Invalid -alert-batch-bypass: %v", err)
+		}
+	}
+	quietLocation := location
+	if *quietHoursTimezone != "" {
+		if quietLocation, err = time.LoadLocation(*quietHoursTimezone); err != nil {
+			log.Fatalf("Invalid quiet hours timezone: %v", err)
+		}
 	}
 
-	return resultsCopy
-}
+	var dedupKey *template.Template
+	if *alertDedupKey != "" {
+		dedupKey, err = template.New("dedup_key").Option("missingkey=error").Parse(*alertDedupKey)
+		if err != nil {
+			log.Fatalf("Invalid alert dedup key: %v", err)
+		}
+	}
 
-func main() {
-	log.Println("Starting HTTP check service on port 8080")
+	var proxyAuth *url.Userinfo
+	if *proxyUser != "" {
+		proxyAuth = url.UserPassword(*proxyUser, os.Getenv("MONITOR_PROXY_PASSWORD"))
+	}
+
+	metrics := monitor.NewMetrics(prometheus.DefaultRegisterer, buckets)
+	var alertQueues []*monitor.AlertQueue
+	var alertBatchers []*monitor.AlertBatcher
+	var quietPeriods []*monitor.QuietHours
+	// newMonitor builds a monitor for one group of sites with the
+	// process-wide settings from flags
+	newMonitor := func(name string, group monitor.GroupConfig) *monitor.WebsiteMonitor {
+		wm := monitor.NewWebsiteMonitor(group.Sites)
+		wm.DefaultHeaders = group.DefaultHeaders
+		wm.SourceIP = *sourceIP
+		if group.Interval > 0 {
+			wm.Interval = time.Duration(group.Interval)
+		}
+		wm.StatusHistorySize = *historySize
+		if *statusHistorySize > 0 {
+			wm.StatusHistorySize = *statusHistorySize
+		}
+		wm.LatencyHistorySize = *historySize
+		if *latencyHistorySize > 0 {
+			wm.LatencyHistorySize = *latencyHistorySize
+		}
+		wm.HistoryRetention = *historyRetention
+		wm.MaxSamples = *maxSamples
+		webhook := *alertWebhook
+		if group.AlertWebhook != "" {
+			webhook = group.AlertWebhook
+		}
+		if webhook != "" {
+			alerts := monitor.NewAlertQueue(monitor.WebhookAlerter{URL: webhook}, 100, *alertAttempts, *alertBackoff, deadLetter)
+			alertQueues = append(alertQueues, alerts)
+			wm.Alerter = alerts
+		}
+		if *alertBatch > 0 {
+			batcher := monitor.NewAlertBatcher(wm.Alerter, *alertBatch)
+			batcher.Bypass = *alertBatchBypass
+			alertBatchers = append(alertBatchers, batcher)
+			wm.Alerter = batcher
+		}
+		if *quietHours != "" {
+			quiet, err := monitor.NewQuietHours(wm.Alerter, *quietHours, quietLocation)
+			if err != nil {
+				log.Fatalf("Invalid quiet hours: %v", err)
+			}
+			quiet.Urgent = *quietHoursSeverity
+			quiet.Clock = wm.Clock
+			quietPeriods = append(quietPeriods, quiet)
+			wm.Alerter = quiet
+		}
+		wm.SLO = slo
+		wm.Schedule = *schedule
+		if *allowExec {
+			wm.RegisterChecker(monitor.CheckExec, monitor.ExecChecker{})
+		}
+		wm.MaxConcurrent = *maxConcurrent
+		wm.MaxPerHost = *maxPerHost
+		wm.SkipOverrun = *skipOverrun
+		wm.FreshnessWindow = *freshnessWindow
+		wm.FreshTimeout = *freshTimeout
+		applyTimeouts(wm)
+		wm.PingCacheTTL = *pingCacheTTL
+		wm.SelfAlerts = *selfAlerts
+		if *shuffle {
+			seed := *shuffleSeed
+			if seed == 0 {
+				seed = rand.Uint64()
+			}
+			log.Printf("Shuffling check order with seed %d", seed)
+			wm.Shuffle = rand.New(rand.NewPCG(seed, 0))
+		}
+		if *pushURL != "" {
+			wm.Pusher = &monitor.Pusher{URL: *pushURL, Group: name, HistorySamples: *pushHistory, Attempts: 3, Backoff: time.Second}
+		}
+		if *statsdAddr != "" {
+			wm.Exporters = append(wm.Exporters, &monitor.StatsD{Addr: *statsdAddr, Prefix: *statsdPrefix, Group: name, Tags: *statsdTags})
+		}
+		if *influxURL != "" {
+			wm.Exporters = append(wm.Exporters, &monitor.Influx{URL: *influxURL, Token: *influxToken, Group: name, Attempts: 3, Backoff: time.Second})
+		}
+		wm.Overall = monitor.OverallThresholds{RedFailures: *overallRed, SlowLatency: *overallSlow}
+		wm.Metrics = metrics
+		wm.Group = name
+		wm.DedupKey = dedupKey
+		wm.Location = location
+		wm.RegisterChecker(monitor.CheckHTTP, &monitor.HTTPChecker{
+			Retries:      *retries,
+			RetryDelay:   *retryDelay,
+			MaxClockSkew: *maxClockSkew,
+			FailureBody: monitor.FailureBodyConfig{
+				Enabled:  *logBodyOnFailure,
+				MaxBytes: *failureBodyBytes,
+				InResult: *failureBodyInResult,
+				Redact:   redact,
+			},
+			// An empty -redact-headers hides nothing rather than the defaults
+			RedactHeaders: append([]string{}, splitList(*redactHeaders)...),
+			ProxyAuth:     proxyAuth,
+		})
+		if err := wm.ValidateSites(); err != nil {
+			log.Fatalf("Invalid config: %v", err)
+		}
+		return wm
+	}
 
-	websites := []string{
-		"google.com",
-		"https://todoappdb-kaushiksahu18.onrender.com/",
-		"https://theconnect-fu5n.onrender.com/",
-		"https://all-in-one-server-thud.onrender.com/",
+	wm := newMonitor("", monitor.GroupConfig{Sites: cfg.Sites, DefaultHeaders: cfg.DefaultHeaders})
+	wm.AllowEmpty = len(cfg.Sites) == 0 && len(cfg.Groups) > 0
+	monitors := []*monitor.WebsiteMonitor{wm}
+	groups := make(map[string]*monitor.WebsiteMonitor, len(cfg.Groups))
+	for name, group := range cfg.Groups {
+		groups[name] = newMonitor(name, group)
+		monitors = append(monitors, groups[name])
+		log.Printf("Monitoring group %q with %d site(s)", name, len(group.Sites))
 	}
 
-	monitor := NewWebsiteMonitor(websites)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	monitor.StartMonitoring(ctx)
+	var selfTest *monitor.SelfTest
+	if *selfTestURL != "" {
+		selfTest = &monitor.SelfTest{
+			URL:      *selfTestURL,
+			Timeout:  10 * time.Second,
+			Checker:  &monitor.HTTPChecker{ProxyAuth: proxyAuth},
+			Interval: *selfTestInterval,
+		}
+		selfTest.Start(ctx)
+	}
+
+	for _, m := range monitors {
+		m.StartMonitoring(ctx)
+	}
+	if *waitInitial {
+		log.Println("Waiting for the first check of every site before serving")
+		for _, m := range monitors {
+			if err := m.WaitInitial(ctx); err != nil {
+				log.Printf("Stopped waiting for the first checks: %v", err)
+				break
+			}
+		}
+	}
 
-	mux := http.NewServeMux()
+	// routes builds the API, with guard protecting the mutating endpoints
+	routes := func(guard func(http.HandlerFunc) http.HandlerFunc) *http.ServeMux {
+		mux := http.NewServeMux()
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprint(w, `
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				monitor.WriteError(w, http.StatusNotFound, "Not found")
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `
 	<!DOCTYPE html>
 	<html>
 	<head>
@@ -165,21 +408,101 @@ func main() {
 		<p class="link">View monitoring results: <a href="/ping">/ping</a></p>
 	</body>
 	</html>`)
-	})
+		})
 
-	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(monitor.GetResults())
-	})
+		mux.HandleFunc("/ping", monitor.PingHandler(wm))
+		mux.HandleFunc("/ping.txt", monitor.PingTextHandler(wm))
+		mux.HandleFunc("/ping.ndjson", monitor.PingNDJSONHandler(wm))
+		mux.HandleFunc("/ping/{group}", monitor.GroupPingHandler(groups))
+		mux.HandleFunc("GET /stats", monitor.StatsHandler(wm))
+		mux.HandleFunc("GET /stats/{group}", monitor.GroupHandler(groups, monitor.StatsHandler))
+
+		mux.HandleFunc("GET /config", guard(monitor.ConfigHandler(wm)))
+		mux.HandleFunc("GET /config/{group}", guard(monitor.GroupHandler(groups, monitor.ConfigHandler)))
+		mux.HandleFunc("GET /diagnose/{host}", guard(monitor.DiagnoseHandler(monitors)))
+		mux.HandleFunc("POST /sites", guard(monitor.AddSiteHandler(wm)))
+		mux.HandleFunc("POST /sites/{host}/note", guard(monitor.NoteHandler(monitors)))
+		mux.HandleFunc("DELETE /sites/{host}/note", guard(monitor.NoteHandler(monitors)))
+		mux.HandleFunc("POST /sites/{host}/golden", guard(monitor.GoldenHandler(monitors)))
+		mux.HandleFunc("POST /history/import", guard(monitor.ImportHistoryHandler(monitors)))
+		mux.HandleFunc("POST /pause-all", guard(monitor.PauseHandler(monitors, true)))
+		mux.HandleFunc("POST /resume-all", guard(monitor.PauseHandler(monitors, false)))
+		mux.HandleFunc("POST /reset", guard(monitor.ResetHandler(monitors)))
+		mux.HandleFunc("POST /reset/{host...}", guard(monitor.ResetHandler(monitors)))
+		if *allowTestAlert {
+			mux.HandleFunc("POST /test-alert/{host}", guard(monitor.TestAlertHandler(monitors)))
+		}
 
-	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
+		mux.Handle("/metrics", promhttp.Handler())
+
+		// Browsers ask for an icon on every visit; answer without content
+		mux.HandleFunc("GET /favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		mux.HandleFunc("/healthz", monitor.HealthzHandler(selfTest))
+		return mux
+	}
+
+	// The public listener filters clients and requires the API token; the
+	// internal one trusts everything that can reach it
+	withToken := func(next http.HandlerFunc) http.HandlerFunc { return requireToken(*apiToken, next) }
+	unguarded := func(next http.HandlerFunc) http.HandlerFunc { return next }
+
+	servers := []*http.Server{{
+		Addr:         *listen,
+		Handler:      ipFilter.Middleware(limiter.Middleware(routes(withToken))),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
+	}}
+	if *internalListen != "" {
+		servers = append(servers, &http.Server{
+			Addr:         *internalListen,
+			Handler:      routes(unguarded),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		})
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	for _, server := range servers {
+		go func() {
+			log.Printf("Serving API on %s", server.Addr)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start server on %s: %v", server.Addr, err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	log.Printf("Shutting down, waiting up to %s for in-flight work", *shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	var serving sync.WaitGroup
+	for _, server := range servers {
+		serving.Go(func() {
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("HTTP server shutdown on %s: %v", server.Addr, err)
+			}
+		})
+	}
+	serving.Wait()
+	running := 0
+	for _, m := range monitors {
+		running += m.Shutdown(shutdownCtx)
+	}
+	if running > 0 {
+		log.Printf("Forced exit with %d check(s) still running", running)
+	}
+	for _, quiet := range quietPeriods {
+		quiet.Close()
+	}
+	for _, batcher := range alertBatchers {
+		batcher.Close()
+	}
+	for _, alerts := range alertQueues {
+		alerts.Close(shutdownCtx)
 	}
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+
+	"ping/monitor"
+)
+
+// IPFilter restricts access to the API based on the client's source address
+type IPFilter struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	trusted []*net.IPNet
+	exempt  map[string]bool
+}
+
+// NewIPFilter creates a filter from allow, deny and trusted proxy CIDR lists.
+// Requests to exempt paths are never filtered.
+func NewIPFilter(allow, deny, trusted, exempt []string) (*IPFilter, error) {
+	f := &IPFilter{exempt: make(map[string]bool)}
+
+	var err error
+	if f.allow, err = parseCIDRs(allow); err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	if f.deny, err = parseCIDRs(deny); err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+	if f.trusted, err = parseCIDRs(trusted); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy list: %w", err)
+	}
+	for _, path := range exempt {
+		f.exempt[path] = true
+	}
+
+	return f, nil
+}
+
+// Middleware wraps next, rejecting disallowed clients with 403
+func (f *IPFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := f.clientIP(r)
+		if !f.allowed(ip) {
+			log.Printf("Rejected request from %v to %s", ip, r.URL.Path)
+			monitor.WriteError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowed reports whether ip may access the API. Deny rules take precedence
+// over allow rules, and an empty allow list permits everyone not denied.
+func (f *IPFilter) allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// clientIP determines the source address of the request, honoring
+// X-Forwarded-For only when the direct peer is a trusted proxy
+func (f *IPFilter) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(f.trusted, ip) {
+		return ip
+	}
+
+	// Walk the chain from the nearest hop, skipping our own proxies
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(f.trusted, hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// RateLimiter rejects API requests beyond a token-bucket rate so heavy
+// polling can't starve the monitor
+type RateLimiter struct {
+	limiter *rate.Limiter
+	exempt  map[string]bool
+}
+
+// NewRateLimiter creates a limiter allowing rps requests per second with
+// the given burst. A non-positive rps disables limiting.
+func NewRateLimiter(rps float64, burst int, exempt []string) *RateLimiter {
+	l := &RateLimiter{exempt: make(map[string]bool)}
+	if rps > 0 {
+		if burst < 1 {
+			burst = 1
+		}
+		l.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+	}
+	for _, path := range exempt {
+		l.exempt[path] = true
+	}
+	return l
+}
+
+// Middleware wraps next, answering 429 with Retry-After when over the limit
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.limiter == nil || l.exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res := l.limiter.Reserve()
+		if delay := res.Delay(); delay > 0 {
+			res.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			monitor.WriteError(w, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseCIDRs parses a list of CIDR blocks, accepting bare IPs as single hosts
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", v)
+			}
+			if ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether ip falls within any of the given networks
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
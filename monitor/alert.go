@@ -0,0 +1,543 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alert severities, from most to least urgent
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Alert is a notification about a change in a site's state
+type Alert struct {
+	Site    string    `json:"site"`
+	Name    string    `json:"name,omitempty"`
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+
+	// Severity is how urgent the alert is, for routing and quiet hours
+	Severity string `json:"severity,omitempty"`
+
+	// Alerts holds the individual alerts of a batched notification
+	Alerts []Alert `json:"alerts,omitempty"`
+
+	// Test marks synthetic alerts raised through /test-alert
+	Test bool `json:"test,omitempty"`
+
+	// DedupKey groups alerts about the same incident in backends that
+	// deduplicate, such as PagerDuty's dedup_key. It depends only on the
+	// site, never the event, so a down alert and the up alert that follows
+	// share a key and the recovery resolves the incident it opened.
+	DedupKey string `json:"dedup_key,omitempty"`
+}
+
+// ValidateSeverity checks that s is critical, warning or info
+func ValidateSeverity(s string) error {
+	if severityRank(s) > severityRank(SeverityInfo) {
+		return fmt.Errorf("invalid severity %q: must be %s, %s or %s", s, SeverityCritical, SeverityWarning, SeverityInfo)
+	}
+	return nil
+}
+
+// alertSeverity is the severity of a site's alerts for event. A site going
+// down or coming back up takes the site's severity, so a page and its
+// resolution are never split by quiet hours; other events are never more
+// urgent than the site.
+func alertSeverity(site SiteConfig, event string) string {
+	severity := site.Severity
+	if severity == "" {
+		severity = SeverityWarning
+	}
+	if event == "down" || event == "up" {
+		return severity
+	}
+	if other := eventSeverity(event); severityRank(other) > severityRank(severity) {
+		return other
+	}
+	return severity
+}
+
+// eventSeverity is the severity of alerts raised for event when no site
+// severity applies
+func eventSeverity(event string) string {
+	switch event {
+	case "down", "up":
+		return SeverityCritical
+	case "first_result":
+		return SeverityInfo
+	default:
+		return SeverityWarning
+	}
+}
+
+// dedupKeyData is the data available to the alert dedup key template
+type dedupKeyData struct {
+	Site string
+	Host string
+}
+
+// dedupKey renders the dedup key for site, defaulting to the site URL
+func (wm *WebsiteMonitor) dedupKey(site string) string {
+	if wm.DedupKey == nil {
+		return site
+	}
+
+	host := site
+	if u, err := url.Parse(site); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	var buf bytes.Buffer
+	if err := wm.DedupKey.Execute(&buf, dedupKeyData{Site: site, Host: host}); err != nil {
+		log.Printf("Failed to render dedup key for %s, using the URL: %v", site, err)
+		return site
+	}
+	return buf.String()
+}
+
+// Alerter delivers alerts to a notification backend
+type Alerter interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// label returns the site's display name, falling back to its URL
+func (a Alert) label() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Site
+}
+
+// LogAlerter writes alerts to the log
+type LogAlerter struct{}
+
+// Send logs the alert
+func (LogAlerter) Send(ctx context.Context, alert Alert) error {
+	log.Printf("ALERT [%s] %s: %s", alert.Event, alert.label(), alert.Message)
+	return nil
+}
+
+// WebhookAlerter POSTs alerts as JSON to a URL
+type WebhookAlerter struct {
+	URL    string
+	Client *http.Client
+}
+
+// Send posts the alert to the webhook
+func (a WebhookAlerter) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// AlertBatcher is an Alerter that collects alerts arriving within a short
+// window and forwards them as one consolidated notification, so a shared
+// outage raises one alert instead of one per site
+type AlertBatcher struct {
+	next   Alerter
+	window time.Duration
+
+	// Bypass is the least urgent severity sent straight away instead of
+	// being batched, e.g. critical; empty batches every alert
+	Bypass string
+
+	mu      sync.Mutex
+	pending []Alert
+	timer   *time.Timer
+}
+
+// NewAlertBatcher batches alerts over window before passing them to next
+func NewAlertBatcher(next Alerter, window time.Duration) *AlertBatcher {
+	return &AlertBatcher{next: next, window: window}
+}
+
+// Send adds the alert to the current batch, starting one if needed, or
+// forwards it at once when it is urgent enough to bypass batching
+func (b *AlertBatcher) Send(ctx context.Context, alert Alert) error {
+	if b.Bypass != "" && severityRank(alert.Severity) <= severityRank(b.Bypass) {
+		return b.next.Send(ctx, alert)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, alert)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	return nil
+}
+
+// Close sends any pending batch straight away
+func (b *AlertBatcher) Close() {
+	b.mu.Lock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.mu.Unlock()
+	b.flush()
+}
+
+// flush forwards the pending alerts, consolidating them when there are
+// several
+func (b *AlertBatcher) flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending, b.timer = nil, nil
+	b.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	alert := pending[0]
+	if len(pending) > 1 {
+		alert = batchAlert(pending)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.next.Send(ctx, alert); err != nil {
+		log.Printf("Failed to deliver %s alert for %s: %v", alert.Event, alert.Site, err)
+	}
+}
+
+// batchAlert consolidates several alerts into one listing every affected
+// site, as urgent as the most urgent of them
+func batchAlert(alerts []Alert) Alert {
+	var sites []string
+	seen := make(map[string]bool)
+	lines := make([]string, 0, len(alerts))
+	severity := ""
+	for _, a := range alerts {
+		if severity == "" || severityRank(a.Severity) < severityRank(severity) {
+			severity = a.Severity
+		}
+		if !seen[a.Site] {
+			seen[a.Site] = true
+			sites = append(sites, a.Site)
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", a.Event, a.label(), a.Message))
+	}
+
+	return Alert{
+		Site:     strings.Join(sites, ", "),
+		Event:    "batch",
+		Message:  fmt.Sprintf("%d alerts for %d site(s):\n%s", len(alerts), len(sites), strings.Join(lines, "\n")),
+		Time:     alerts[len(alerts)-1].Time,
+		Severity: severity,
+		Alerts:   alerts,
+	}
+}
+
+// severityRank orders severities from most urgent (0) to least
+func severityRank(severity string) int {
+	switch severity {
+	case SeverityCritical:
+		return 0
+	case SeverityWarning:
+		return 1
+	case SeverityInfo:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// streak counts a site's consecutive failed and successful checks and
+// whether it is currently considered down. failingSince is when the current
+// run of failures began, and lastDowntime how long the previous run lasted.
+type streak struct {
+	failures  int
+	successes int
+	down      bool
+
+	failingSince time.Time
+	lastDowntime time.Duration
+}
+
+// record counts the result and returns a "down" alert once the site has
+// failed AlertAfterFailures checks in a row, or an "up" alert once a down
+// site has succeeded RecoverAfterSuccesses checks in a row
+func (s *streak) record(site SiteConfig, result PingResult) (Alert, bool) {
+	if healthy(result.Status) {
+		if s.failures > 0 {
+			s.lastDowntime = result.CheckedAt.Sub(s.failingSince)
+		}
+		s.failures = 0
+		s.successes++
+	} else {
+		if s.failures == 0 {
+			s.failingSince = result.CheckedAt
+		}
+		s.successes = 0
+		s.failures++
+	}
+
+	switch {
+	case !s.down && s.failures >= max(site.AlertAfterFailures, 1):
+		s.down = true
+		return Alert{
+			Site:    site.URL,
+			Event:   "down",
+			Message: fmt.Sprintf("Site down after %d consecutive failure(s): %s", s.failures, result.Error),
+			Time:    result.CheckedAt,
+		}, true
+	case s.down && s.successes >= max(site.RecoverAfterSuccesses, 1):
+		s.down = false
+		return Alert{
+			Site:  site.URL,
+			Event: "up",
+			Message: fmt.Sprintf("Site recovered after %d consecutive success(es), down for %s",
+				s.successes, formatDowntime(s.lastDowntime)),
+			Time: result.CheckedAt,
+		}, true
+	}
+	return Alert{}, false
+}
+
+// apply reports the site's ongoing and most recent downtime on result
+func (s *streak) apply(result *PingResult) {
+	result.ConsecutiveFailures = s.failures
+	if s.failures > 0 {
+		result.CurrentDowntime = formatDowntime(result.CheckedAt.Sub(s.failingSince))
+	}
+	if s.lastDowntime > 0 {
+		result.LastDowntime = formatDowntime(s.lastDowntime)
+	}
+}
+
+// formatDowntime rounds a downtime to whole seconds for display
+func formatDowntime(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+// errQueueFull is returned when an alert can't be queued for delivery
+var errQueueFull = errors.New("alert queue full")
+
+// AlertQueue is an Alerter that delivers alerts to another Alerter from a
+// buffered queue, retrying failures with jittered exponential backoff.
+// Alerts that are never delivered are written to the dead-letter log.
+type AlertQueue struct {
+	alerter     Alerter
+	maxAttempts int
+	backoff     time.Duration
+	deadLetter  io.Writer
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan Alert
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewAlertQueue starts delivering queued alerts to alerter, trying each up
+// to maxAttempts times with backoff doubling from the given delay.
+// Undeliverable alerts are logged and, if deadLetter is non-nil, appended
+// to it as JSON lines.
+func NewAlertQueue(alerter Alerter, size, maxAttempts int, backoff time.Duration, deadLetter io.Writer) *AlertQueue {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	q := &AlertQueue{
+		alerter:     alerter,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		deadLetter:  deadLetter,
+		queue:       make(chan Alert, size),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Send queues the alert without waiting for delivery. Alerts that can't
+// be queued go straight to the dead-letter log.
+func (q *AlertQueue) Send(ctx context.Context, alert Alert) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		q.bury(alert, 0, errors.New("alert queue closed"))
+		return nil
+	}
+	select {
+	case q.queue <- alert:
+		return nil
+	default:
+		q.bury(alert, 0, errQueueFull)
+		return nil
+	}
+}
+
+// Close stops accepting alerts and waits for queued ones to be delivered.
+// If ctx expires first, retries are abandoned and what's left goes to the
+// dead-letter log.
+func (q *AlertQueue) Close(ctx context.Context) {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		close(q.stop)
+		<-q.done
+	}
+}
+
+// run delivers queued alerts one at a time, in order
+func (q *AlertQueue) run() {
+	defer close(q.done)
+	for alert := range q.queue {
+		q.deliver(alert)
+	}
+}
+
+// deliver sends alert, retrying until it succeeds or attempts run out
+func (q *AlertQueue) deliver(alert Alert) {
+	var err error
+	for attempt := 1; attempt <= q.maxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = q.alerter.Send(ctx, alert)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt == q.maxAttempts {
+			break
+		}
+
+		delay := jitter(q.backoff << (attempt - 1))
+		log.Printf("Failed to deliver %s alert for %s (attempt %d/%d), retrying in %s: %v",
+			alert.Event, alert.Site, attempt, q.maxAttempts, delay.Round(time.Millisecond), err)
+
+		select {
+		case <-time.After(delay):
+		case <-q.stop:
+			q.bury(alert, attempt, err)
+			return
+		}
+	}
+	q.bury(alert, q.maxAttempts, err)
+}
+
+// bury records an alert that could not be delivered
+func (q *AlertQueue) bury(alert Alert, attempts int, err error) {
+	log.Printf("DEAD LETTER %s alert for %s after %d attempt(s): %v", alert.Event, alert.Site, attempts, err)
+	if q.deadLetter == nil {
+		return
+	}
+
+	line, _ := json.Marshal(struct {
+		Alert
+		Attempts int    `json:"attempts"`
+		Error    string `json:"error"`
+	}{alert, attempts, err.Error()})
+	if _, werr := q.deadLetter.Write(append(line, '\n')); werr != nil {
+		log.Printf("Failed to write dead-letter log: %v", werr)
+	}
+}
+
+// jitter returns a random duration between half and all of d
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// TestAlert sends a synthetic down alert followed by its recovery for each
+// site matching host, without touching the sites' recorded state, so the
+// alert pipeline can be verified end to end. It returns the number of sites
+// matched.
+func (wm *WebsiteMonitor) TestAlert(host string) int {
+	matched := 0
+	for _, site := range wm.Sites() {
+		if !matchesHost(site.URL, host) {
+			continue
+		}
+		now := wm.Clock.Now()
+		wm.sendAlerts(
+			Alert{Site: site.URL, Name: site.Name, Event: "down", Message: "Test alert: synthetic failure", Time: now,
+				Severity: alertSeverity(site, "down"), Test: true},
+			Alert{Site: site.URL, Name: site.Name, Event: "up", Message: "Test alert: synthetic recovery", Time: now,
+				Severity: alertSeverity(site, "up"), Test: true},
+		)
+		matched++
+	}
+	return matched
+}
+
+// sendAlert delivers an alert in the background so checks aren't held up
+// by a slow receiver
+func (wm *WebsiteMonitor) sendAlert(alert Alert) {
+	wm.sendAlerts(alert)
+}
+
+// sendAlerts delivers alerts in order in the background
+func (wm *WebsiteMonitor) sendAlerts(alerts ...Alert) {
+	if wm.Alerter == nil {
+		return
+	}
+
+	for i := range alerts {
+		if alerts[i].Site != "" && alerts[i].DedupKey == "" {
+			alerts[i].DedupKey = wm.dedupKey(alerts[i].Site)
+		}
+		if alerts[i].Severity == "" {
+			alerts[i].Severity = eventSeverity(alerts[i].Event)
+		}
+	}
+
+	go func() {
+		for _, alert := range alerts {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := wm.Alerter.Send(ctx, alert); err != nil {
+				log.Printf("Failed to deliver %s alert for %s: %v", alert.Event, alert.Site, err)
+			}
+			cancel()
+		}
+	}()
+}
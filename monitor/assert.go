@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Assertion modes for combining a site's success criteria
+const (
+	// AssertAll requires every assertion to pass
+	AssertAll = "all"
+	// AssertAny requires at least one assertion to pass
+	AssertAny = "any"
+)
+
+// AssertionResult is the outcome of one of a check's assertions
+type AssertionResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ruleFailure describes why an assertion failed
+type ruleFailure struct {
+	kind   string
+	msg    string
+	reason string
+
+	// withBody attaches the response body to the failure
+	withBody bool
+}
+
+// ruleSet combines a check's assertions, either requiring all of them
+// (AND) or any one of them (OR)
+type ruleSet struct {
+	any      bool
+	results  []AssertionResult
+	failures []*ruleFailure
+	passed   bool
+}
+
+// eval records the outcome of the named assertion, where a nil failure is
+// a pass. It reports whether the outcome is already decided and the
+// remaining assertions can be skipped, which in AND mode is at the first
+// failure.
+func (rs *ruleSet) eval(name string, failure *ruleFailure) bool {
+	if failure == nil {
+		rs.results = append(rs.results, AssertionResult{Name: name, Passed: true})
+		rs.passed = true
+		return false
+	}
+	rs.results = append(rs.results, AssertionResult{Name: name, Error: failure.msg})
+	rs.failures = append(rs.failures, failure)
+	return !rs.any
+}
+
+// failure returns why the check failed, or nil if its assertions are
+// satisfied. In OR mode with every assertion failing, the failures are
+// combined, and the check is retryable if any of them is.
+func (rs *ruleSet) failure() *ruleFailure {
+	if len(rs.failures) == 0 || (rs.any && rs.passed) {
+		return nil
+	}
+	if !rs.any || len(rs.failures) == 1 {
+		return rs.failures[0]
+	}
+
+	combined := &ruleFailure{kind: FailureDeterministic}
+	msgs := make([]string, len(rs.failures))
+	for i, f := range rs.failures {
+		msgs[i] = f.msg
+		if f.kind == FailureRetryable {
+			combined.kind = FailureRetryable
+		}
+		combined.withBody = combined.withBody || f.withBody
+	}
+	combined.msg = fmt.Sprintf("No assertion passed: %s", strings.Join(msgs, "; "))
+	return combined
+}
@@ -0,0 +1,60 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// FailureBodyConfig controls capturing response bodies of failed checks
+type FailureBodyConfig struct {
+	Enabled  bool
+	MaxBytes int
+	InResult bool
+	Redact   []*regexp.Regexp
+}
+
+// CompileRedactPatterns compiles the sensitive patterns to scrub from bodies
+func CompileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// capture reads up to MaxBytes of body, redacting sensitive matches and
+// marking the result when it was truncated. Redaction runs on the body
+// before it is cut, so a secret straddling the cut is still hidden.
+func (c FailureBodyConfig) capture(body io.Reader) string {
+	limit := c.MaxBytes
+	if limit <= 0 {
+		limit = 512
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxBodyBytes))
+	if err != nil && len(data) == 0 {
+		return fmt.Sprintf("<unreadable body: %v>", err)
+	}
+
+	text := string(data)
+	for _, re := range c.Redact {
+		text = re.ReplaceAllString(text, "[REDACTED]")
+	}
+
+	truncated := len(text) > limit
+	if truncated {
+		text = text[:limit]
+	}
+	text = strings.ToValidUTF8(text, "?")
+	if truncated {
+		text += "...(truncated)"
+	}
+
+	return text
+}
@@ -0,0 +1,23 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCaptureRedactsBeforeTruncating(t *testing.T) {
+	redact, err := CompileRedactPatterns([]string{`token=[a-z0-9]{8,64}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := FailureBodyConfig{MaxBytes: 16, Redact: redact}
+
+	// The cut falls inside the secret
+	got := c.capture(strings.NewReader("error: token=abcdef0123456789"))
+	if strings.Contains(got, "abc") {
+		t.Errorf("capture leaked part of the secret: %q", got)
+	}
+	if !strings.HasSuffix(got, "...(truncated)") {
+		t.Errorf("capture = %q, want it marked truncated", got)
+	}
+}
@@ -0,0 +1,597 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported check types
+const (
+	CheckHTTP      = "http"
+	CheckTCP       = "tcp"
+	CheckDNS       = "dns"
+	CheckWebSocket = "websocket"
+	CheckHTTP3     = "http3"
+	CheckExec      = "exec"
+)
+
+// Failure classifications reported in PingResult.FailureKind
+const (
+	// FailureRetryable failures may succeed on another attempt
+	FailureRetryable = "retryable"
+	// FailureDeterministic failures will not change by retrying
+	FailureDeterministic = "deterministic"
+)
+
+// SiteConfig describes a single monitored target
+type SiteConfig struct {
+	URL       string `json:"url"`
+	CheckType string `json:"check_type,omitempty"`
+
+	// Name is shown in place of URL in the text view and alerts; the URL
+	// still identifies the site everywhere else
+	Name string `json:"name,omitempty"`
+
+	// Enabled can be set to false to keep a site in the config without
+	// checking it
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Timeout bounds a whole check, including any retries and the delays
+	// between them; 0 uses the monitor's total timeout, 5s by default
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// RequestTimeout bounds each attempt of an HTTP check within Timeout,
+	// so a hung attempt leaves time for a retry; 0 uses the monitor's
+	// request timeout, and if that is 0 too an attempt may use whatever
+	// is left of Timeout
+	RequestTimeout Duration `json:"request_timeout,omitempty"`
+
+	// ConnectTimeout bounds establishing the connection, separately from
+	// Timeout, which bounds the whole check; 0 leaves only Timeout
+	ConnectTimeout Duration `json:"connect_timeout,omitempty"`
+
+	// WarmupTimeout is used instead of Timeout for the check after a
+	// failure, giving scale-to-zero backends time to cold start. The site
+	// returns to Timeout once a check succeeds within it.
+	WarmupTimeout Duration `json:"warmup_timeout,omitempty"`
+
+	// Port overrides the port in URL when set
+	Port int `json:"port,omitempty"`
+
+	// SourceIP is the local address checks are sent from, overriding the
+	// monitor's default
+	SourceIP string `json:"source_ip,omitempty"`
+
+	// Proxy is the forward proxy HTTP checks go through; empty uses the
+	// HTTP_PROXY and HTTPS_PROXY environment variables
+	Proxy string `json:"proxy,omitempty"`
+
+	// DNSRecordType is the record type (A, AAAA, CNAME, MX, NS or TXT)
+	// DNS checks query, and DNSExpect the values that must be among its
+	// records. Without either, DNS checks only require the host to resolve.
+	DNSRecordType string   `json:"dns_record_type,omitempty"`
+	DNSExpect     []string `json:"dns_expect,omitempty"`
+
+	// Command is the program and arguments run by exec checks
+	Command []string `json:"command,omitempty"`
+
+	// Headers are sent with every request to the site, overriding any
+	// monitor-wide default headers of the same name
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// CaptureHeaders records the HTTP response headers in each result,
+	// with sensitive ones redacted
+	CaptureHeaders bool `json:"capture_headers,omitempty"`
+
+	// ServerName is the TLS server name (SNI) presented and verified
+	// instead of the URL's host, and HostHeader the HTTP Host header sent
+	// instead of it, overriding any Host in Headers. Together they let a
+	// URL naming one backend's IP be checked as the shared hostname.
+	ServerName string `json:"server_name,omitempty"`
+	HostHeader string `json:"host_header,omitempty"`
+
+	// MinTLSVersion is the oldest TLS version ("1.0" to "1.3") the site may
+	// negotiate; older versions fail the check
+	MinTLSVersion string `json:"min_tls_version,omitempty"`
+
+	// Query parameters are added to the request URL. Values may use
+	// {{.Timestamp}} for a cache-busting Unix timestamp.
+	Query map[string]string `json:"query,omitempty"`
+
+	// Paths lists several paths to check on the same host. Results are
+	// reported per path, nested under the site.
+	Paths []string `json:"paths,omitempty"`
+
+	// DisableKeepAlive opens a fresh connection for every check so the
+	// full handshake is measured
+	DisableKeepAlive bool `json:"disable_keep_alive,omitempty"`
+
+	// HTTP10 sends requests as HTTP/1.0 with Connection: close, for
+	// legacy servers that don't speak HTTP/1.1. Proxies aren't used.
+	HTTP10 bool `json:"http10,omitempty"`
+
+	// Conditional sends If-None-Match/If-Modified-Since based on the last
+	// full response, treating 304 Not Modified as success
+	Conditional bool `json:"conditional,omitempty"`
+
+	// StatusMap maps HTTP status codes to the result status they stand
+	// for, such as 503 to "draining". Mapping to "success" or "failed"
+	// overrides the usual verdict; any other status is reported as is and
+	// neither alerts nor counts towards uptime.
+	StatusMap map[int]string `json:"status_map,omitempty"`
+
+	// RetryOnStatus lists the failing HTTP statuses that are retried, such
+	// as 502, 503 and 504 from a warming load balancer; empty retries any
+	// 5xx. Transport errors are retried either way.
+	RetryOnStatus []int `json:"retry_on_status,omitempty"`
+
+	// MaxRedirects limits how many redirects are followed; 0 uses the
+	// default of 5
+	MaxRedirects int `json:"max_redirects,omitempty"`
+
+	// ExpectFinalURL is the URL the site must end up at after following
+	// redirects
+	ExpectFinalURL string `json:"expect_final_url,omitempty"`
+
+	// ExpectHTTPSUpgrade requires an http:// site to redirect to https,
+	// verifying HTTPS is enforced
+	ExpectHTTPSUpgrade bool `json:"expect_https_upgrade,omitempty"`
+
+	// SlowThresholdMs marks successful checks slower than it, in
+	// milliseconds, as "slow": still up, but degraded. 0 disables it.
+	SlowThresholdMs float64 `json:"slow_threshold_ms,omitempty"`
+
+	// MaxTTFBMs fails HTTP checks whose time to first byte exceeds it, in
+	// milliseconds; 0 disables the check
+	MaxTTFBMs float64 `json:"max_ttfb_ms,omitempty"`
+
+	// WebSocketPing sends a ping after the websocket handshake and
+	// requires a pong in reply
+	WebSocketPing bool `json:"websocket_ping,omitempty"`
+
+	// AssertionMode combines the site's assertions (status, TTFB, final URL,
+	// golden, body size and body): "all" (the default) requires every one to pass,
+	// "any" requires just one
+	AssertionMode string `json:"assertion_mode,omitempty"`
+
+	// ExpectBody is a substring the HTTP response body must contain
+	ExpectBody string `json:"expect_body,omitempty"`
+
+	// ExpectBodyRegex is a regular expression the HTTP response body must
+	// match
+	ExpectBodyRegex string `json:"expect_body_regex,omitempty"`
+
+	// ExpectBodyAbsent lists substrings the HTTP response body must not
+	// contain, catching error pages served with a 200
+	ExpectBodyAbsent []string `json:"expect_body_absent,omitempty"`
+
+	// MinBytes and MaxBytes bound the size of the HTTP response body after
+	// decompression, catching empty or unexpectedly large responses; 0
+	// leaves that side unbounded. Bodies are read up to 1 MiB, so MaxBytes
+	// must be below that.
+	MinBytes int `json:"min_bytes,omitempty"`
+	MaxBytes int `json:"max_bytes,omitempty"`
+
+	// ExpectCompression requests gzip and fails unless the response is
+	// gzip-encoded, recording the compression ratio
+	ExpectCompression bool `json:"expect_compression,omitempty"`
+
+	// AlertAfterFailures is how many consecutive failed checks raise a
+	// "down" alert, and RecoverAfterSuccesses how many consecutive
+	// successes then raise an "up" alert; both default to 1
+	AlertAfterFailures    int `json:"alert_after_failures,omitempty"`
+	RecoverAfterSuccesses int `json:"recover_after_successes,omitempty"`
+
+	// Severity is how urgent the site's alerts are (critical, warning or
+	// info), for routing downstream and by quiet hours and batching;
+	// empty is warning
+	Severity string `json:"severity,omitempty"`
+
+	// DetectContentChange hashes the response body (up to 1 MiB) and flags
+	// results whose body differs from the previous check's.
+	// AlertOnContentChange also sends a content_changed alert.
+	DetectContentChange  bool `json:"detect_content_change,omitempty"`
+	AlertOnContentChange bool `json:"alert_on_content_change,omitempty"`
+
+	// CompareToGolden fails the check when the response's structure (its
+	// status and JSON keys and types) differs from the golden captured via
+	// POST /sites/{host}/golden. GoldenIgnore lists dotted JSON paths, such
+	// as "data.updated_at", left out of the comparison.
+	CompareToGolden bool     `json:"compare_to_golden,omitempty"`
+	GoldenIgnore    []string `json:"golden_ignore,omitempty"`
+
+	// LatencySLA lists percentile latency objectives evaluated over the
+	// site's history after every check
+	LatencySLA []LatencySLA `json:"latency_sla,omitempty"`
+
+	// Maintenance lists recurring windows during which the site is
+	// expected to be down. Results are marked "maintenance" and neither
+	// alert nor count against uptime.
+	Maintenance []MaintenanceWindow `json:"maintenance,omitempty"`
+
+	bodyRegex *regexp.Regexp
+}
+
+// enabled reports whether the site should be checked
+func (s SiteConfig) enabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// retriesStatus reports whether a failing response with the given status
+// should be retried
+func (s SiteConfig) retriesStatus(code int) bool {
+	if len(s.RetryOnStatus) == 0 {
+		return code >= http.StatusInternalServerError
+	}
+	return slices.Contains(s.RetryOnStatus, code)
+}
+
+// timeout returns how long a single check of the site may take
+func (s SiteConfig) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return time.Duration(s.Timeout)
+	}
+	return defaultCheckTimeout
+}
+
+// slowThreshold returns the latency above which a successful check is
+// slow, or 0 when no threshold is set
+func (s SiteConfig) slowThreshold() time.Duration {
+	return time.Duration(s.SlowThresholdMs * float64(time.Millisecond))
+}
+
+// readsBody reports whether checking the site needs the response body
+func (s SiteConfig) readsBody() bool {
+	return s.ExpectBody != "" || s.ExpectBodyRegex != "" || len(s.ExpectBodyAbsent) > 0 || s.ExpectCompression || s.DetectContentChange || s.CompareToGolden ||
+		s.MinBytes > 0 || s.MaxBytes > 0
+}
+
+// compile prepares the site's regular expressions, failing on bad patterns
+func (s *SiteConfig) compile() error {
+	for name, value := range s.Query {
+		if _, err := parseQueryTemplate(name, value); err != nil {
+			return fmt.Errorf("invalid query parameter %s: %w", name, err)
+		}
+	}
+	for i := range s.Maintenance {
+		if err := s.Maintenance[i].compile(); err != nil {
+			return err
+		}
+	}
+	if s.ExpectBodyRegex == "" {
+		return nil
+	}
+	re, err := regexp.Compile(s.ExpectBodyRegex)
+	if err != nil {
+		return fmt.Errorf("invalid expect_body_regex: %w", err)
+	}
+	s.bodyRegex = re
+	return nil
+}
+
+// bodyPattern returns the compiled ExpectBodyRegex, compiling it on demand
+// for sites that weren't loaded through LoadConfig
+func (s SiteConfig) bodyPattern() (*regexp.Regexp, error) {
+	if s.bodyRegex != nil {
+		return s.bodyRegex, nil
+	}
+	return regexp.Compile(s.ExpectBodyRegex)
+}
+
+// Type returns the configured check type, inferring it from the URL scheme
+// when none was set explicitly
+func (s SiteConfig) Type() string {
+	if s.CheckType != "" {
+		return s.CheckType
+	}
+	if scheme, _, ok := strings.Cut(s.URL, "://"); ok {
+		switch scheme {
+		case CheckTCP, CheckDNS, CheckExec:
+			return scheme
+		case "ws", "wss":
+			return CheckWebSocket
+		}
+	}
+	return CheckHTTP
+}
+
+// Checker performs a single health check against a target
+type Checker interface {
+	Check(ctx context.Context, site SiteConfig) PingResult
+}
+
+// resetter is implemented by checkers that keep state between checks,
+// which a reset of the matching sites clears
+type resetter interface {
+	Reset(host string)
+}
+
+// TCPChecker checks that a TCP connection can be established
+type TCPChecker struct{}
+
+// Check dials the target's host:port
+func (TCPChecker) Check(ctx context.Context, site SiteConfig) PingResult {
+	addr := strings.TrimSuffix(stripScheme(site.URL), "/")
+	if site.Port != 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		addr = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(site.Port))
+	}
+
+	start := time.Now()
+	conn, err := siteDialer(site).DialContext(ctx, "tcp", addr)
+	duration := time.Since(start)
+
+	if err != nil {
+		result := failedResult(fmt.Sprintf("Connection failed: %v", err))
+		result.Reason = timeoutReason(ctx, err)
+		return result
+	}
+	defer conn.Close()
+
+	result := successResult(duration)
+	result.ServedBy = remoteIP(conn.RemoteAddr())
+	return result
+}
+
+// DNS record types DNS checks can query
+var dnsRecordTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true, "MX": true, "NS": true, "TXT": true}
+
+// DNSChecker checks that the target's hostname resolves and, when a record
+// type is configured, that its records hold the expected values
+type DNSChecker struct{}
+
+// Check resolves the target's hostname
+func (DNSChecker) Check(ctx context.Context, site SiteConfig) PingResult {
+	host := stripScheme(site.URL)
+	if u, err := url.Parse("//" + host); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	if site.DNSRecordType != "" || len(site.DNSExpect) > 0 {
+		return checkDNSRecords(ctx, site, host)
+	}
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	duration := time.Since(start)
+
+	if err != nil {
+		return failedResult(fmt.Sprintf("Lookup failed: %v", err))
+	}
+	if len(addrs) == 0 {
+		return failedResult("Lookup returned no addresses")
+	}
+
+	return successResult(duration)
+}
+
+// checkDNSRecords looks up host's records of the site's type and fails
+// unless every expected value is among them. Names compare without case
+// or trailing dot; TXT records match when they contain the expected text.
+func checkDNSRecords(ctx context.Context, site SiteConfig, host string) PingResult {
+	recordType := strings.ToUpper(site.DNSRecordType)
+	if recordType == "" {
+		recordType = "A"
+	}
+
+	start := time.Now()
+	records, err := lookupRecords(ctx, recordType, host)
+	duration := time.Since(start)
+
+	if err != nil {
+		result := failedResult(fmt.Sprintf("%s lookup failed: %v", recordType, err))
+		result.Reason = timeoutReason(ctx, err)
+		return result
+	}
+	if len(records) == 0 {
+		result := failedResult(fmt.Sprintf("No %s records found", recordType))
+		result.Reason = "dns mismatch"
+		return result
+	}
+
+	var missing []string
+	for _, want := range site.DNSExpect {
+		found := false
+		for _, got := range records {
+			if recordType == "TXT" && strings.Contains(got, want) ||
+				recordType != "TXT" && strings.EqualFold(strings.TrimSuffix(got, "."), strings.TrimSuffix(want, ".")) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, want)
+		}
+	}
+
+	result := successResult(duration)
+	result.Records = records
+	if len(missing) > 0 {
+		result.Status = "failed"
+		result.Loss = "100%"
+		result.Error = fmt.Sprintf("Missing %s record(s) %s, found %s",
+			recordType, strings.Join(missing, ", "), strings.Join(records, ", "))
+		result.Reason = "dns mismatch"
+	}
+	return result
+}
+
+// lookupRecords returns host's records of the given type as strings
+func lookupRecords(ctx context.Context, recordType, host string) ([]string, error) {
+	r := net.DefaultResolver
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, host)
+		var out []string
+		for _, ip := range ips {
+			out = append(out, ip.String())
+		}
+		return out, err
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return []string{cname}, nil
+	case "MX":
+		mxs, err := r.LookupMX(ctx, host)
+		var out []string
+		for _, mx := range mxs {
+			out = append(out, mx.Host)
+		}
+		return out, err
+	case "NS":
+		nss, err := r.LookupNS(ctx, host)
+		var out []string
+		for _, ns := range nss {
+			out = append(out, ns.Host)
+		}
+		return out, err
+	case "TXT":
+		return r.LookupTXT(ctx, host)
+	}
+	return nil, fmt.Errorf("unsupported record type %q", recordType)
+}
+
+// siteDialer returns a dialer honoring the site's connect timeout and
+// source IP
+func siteDialer(site SiteConfig) *net.Dialer {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if site.ConnectTimeout > 0 {
+		d.Timeout = time.Duration(site.ConnectTimeout)
+	}
+	if addr := net.ParseIP(site.SourceIP); addr != nil {
+		d.LocalAddr = &net.TCPAddr{IP: addr}
+	}
+	return d
+}
+
+// timeoutReason tells which timeout err was caused by, if any: the check's
+// overall timeout on ctx, or the dialer's connect timeout
+func timeoutReason(ctx context.Context, err error) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "request timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout() {
+		return "connect timeout"
+	}
+	return ""
+}
+
+// ValidateSourceIP checks that ip is an address this host can bind to
+func ValidateSourceIP(ip string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid source IP %q", ip)
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		return fmt.Errorf("source IP %s is not bindable: %w", ip, err)
+	}
+	return l.Close()
+}
+
+// mergeHeaders combines default and site headers. Names are compared
+// case-insensitively and site headers take precedence.
+func mergeHeaders(defaults, site map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return site
+	}
+
+	merged := make(map[string]string, len(defaults)+len(site))
+	for k, v := range defaults {
+		merged[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range site {
+		merged[http.CanonicalHeaderKey(k)] = v
+	}
+	return merged
+}
+
+// setHeaders applies configured headers to req, treating Host specially
+func setHeaders(req *http.Request, headers map[string]string) {
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+}
+
+// stripScheme removes any scheme prefix from a target
+func stripScheme(target string) string {
+	if _, rest, ok := strings.Cut(target, "://"); ok {
+		return rest
+	}
+	return target
+}
+
+// successResult builds a successful result for the given duration
+func successResult(duration time.Duration) PingResult {
+	return PingResult{
+		Status:   "success",
+		Loss:     "0%",
+		AvgTime:  formatDuration(duration),
+		Duration: duration,
+	}
+}
+
+// healthy reports whether a check status means the site is up: it
+// succeeded, possibly slowly
+func healthy(status string) bool {
+	return status == "success" || status == "slow"
+}
+
+// failedResult builds a failed result with the given error message
+func failedResult(msg string) PingResult {
+	return PingResult{
+		Status: "failed",
+		Loss:   "100%",
+		Error:  msg,
+	}
+}
+
+// cancelledResult builds a result for a check interrupted by cancellation.
+// It carries no loss figure since the site's health is unknown.
+func cancelledResult(err error) PingResult {
+	return PingResult{
+		Status: "cancelled",
+		Error:  fmt.Sprintf("Check cancelled: %v", err),
+	}
+}
+
+// retryableFailure builds a failed result that is worth retrying
+func retryableFailure(msg string) PingResult {
+	result := failedResult(msg)
+	result.FailureKind = FailureRetryable
+	return result
+}
+
+// deterministicFailure builds a failed result that retrying won't fix
+func deterministicFailure(msg string) PingResult {
+	result := failedResult(msg)
+	result.FailureKind = FailureDeterministic
+	return result
+}
+
+// formatDuration renders a duration the way results report it
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.2f ms", float64(d.Milliseconds()))
+}
@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPChecker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := TCPChecker{}.Check(ctx, SiteConfig{URL: "tcp://" + addr})
+	if result.Status != "success" {
+		t.Fatalf("status = %q (%s), want success", result.Status, result.Error)
+	}
+	if result.ServedBy != "127.0.0.1" {
+		t.Errorf("served by %q, want 127.0.0.1", result.ServedBy)
+	}
+
+	ln.Close()
+	result = TCPChecker{}.Check(ctx, SiteConfig{URL: "tcp://" + addr})
+	if result.Status != "failed" {
+		t.Errorf("status after close = %q, want failed", result.Status)
+	}
+}
+
+func TestTCPCheckerPortOverride(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	result := TCPChecker{}.Check(ctx, SiteConfig{URL: "tcp://127.0.0.1:1", Port: port})
+	if result.Status != "success" {
+		t.Errorf("status = %q (%s), want success", result.Status, result.Error)
+	}
+}
+
+func TestCheckerRegistry(t *testing.T) {
+	wm := NewWebsiteMonitor(nil)
+
+	result := wm.checkSite(context.Background(), SiteConfig{URL: "https://example.com", CheckType: "carrier-pigeon"})
+	if result.Status != "failed" {
+		t.Errorf("unknown check type: status = %q, want failed", result.Status)
+	}
+
+	if err := wm.AddSite(SiteConfig{URL: "https://example.com", CheckType: "carrier-pigeon"}); err == nil {
+		t.Error("AddSite accepted an unknown check type")
+	}
+
+	stub := checkerFunc(func(ctx context.Context, site SiteConfig) PingResult { return successResult(time.Millisecond) })
+	wm.RegisterChecker("stub", stub)
+	result = wm.checkSite(context.Background(), SiteConfig{URL: "stub://anything", CheckType: "stub"})
+	if result.Status != "success" {
+		t.Errorf("registered check type: status = %q (%s), want success", result.Status, result.Error)
+	}
+}
+
+func TestAddSiteRejectsExecChecks(t *testing.T) {
+	wm := NewWebsiteMonitor(nil)
+	wm.RegisterChecker(CheckExec, ExecChecker{})
+
+	err := wm.AddSite(SiteConfig{URL: "exec://touch", CheckType: CheckExec, Command: []string{"touch", "/tmp/pwned"}})
+	if err == nil {
+		t.Fatal("AddSite accepted an exec check")
+	}
+	if len(wm.Sites()) != 0 {
+		t.Errorf("exec site was added: %v", wm.Sites())
+	}
+}
+
+// checkerFunc adapts a function to the Checker interface
+type checkerFunc func(ctx context.Context, site SiteConfig) PingResult
+
+func (f checkerFunc) Check(ctx context.Context, site SiteConfig) PingResult { return f(ctx, site) }
@@ -0,0 +1,147 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	ticker := clock.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired before its period elapsed")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case got := <-ticker.C():
+		if want := epoch.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("tick at %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("ticker didn't fire once its period elapsed")
+	}
+}
+
+func TestFakeClockAfterFunc(t *testing.T) {
+	clock := NewFakeClock(epoch)
+
+	var fired, stopped atomic.Bool
+	clock.AfterFunc(time.Second, func() { fired.Store(true) })
+	timer := clock.AfterFunc(time.Second, func() { stopped.Store(true) })
+	if !timer.Stop() {
+		t.Error("Stop of a pending timer returned false")
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	if fired.Load() {
+		t.Fatal("timer fired early")
+	}
+	clock.Advance(time.Millisecond)
+	if !fired.Load() {
+		t.Error("timer didn't fire when due")
+	}
+	if stopped.Load() {
+		t.Error("stopped timer fired")
+	}
+}
+
+// TestSchedulingFollowsClock drives the check loop with a fake clock
+// rather than waiting out real intervals
+func TestSchedulingFollowsClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	wm := NewWebsiteMonitor([]SiteConfig{{URL: "stub://a", CheckType: "stub"}})
+	wm.Clock = clock
+	wm.Interval = time.Minute
+	wm.RegisterChecker("stub", checkerFunc(func(ctx context.Context, site SiteConfig) PingResult {
+		return successResult(time.Millisecond)
+	}))
+	results := make(chan PingResult, 10)
+	wm.OnResult(func(site string, result PingResult) { results <- result })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wm.StartMonitoring(ctx)
+
+	next := func() PingResult {
+		t.Helper()
+		select {
+		case result := <-results:
+			return result
+		case <-time.After(5 * time.Second):
+			t.Fatal("no check ran")
+			return PingResult{}
+		}
+	}
+
+	if got := next().CheckedAt; !got.Equal(epoch) {
+		t.Errorf("initial check at %v, want %v", got, epoch)
+	}
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-results:
+		t.Fatal("checked again before the interval elapsed")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if got, want := next().CheckedAt, epoch.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("second check at %v, want %v", got, want)
+	}
+
+	cancel()
+	wm.Shutdown(context.Background())
+}
+
+// TestHTTPCheckerRetryDelayUsesClock shows retries wait on the monitor's
+// clock, so a long retry delay costs no real time
+func TestHTTPCheckerRetryDelayUsesClock(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	clock := NewFakeClock(epoch)
+	c := &HTTPChecker{Retries: 1, RetryDelay: time.Hour}
+	done := make(chan PingResult)
+	go func() {
+		done <- c.Check(withClock(context.Background(), clock), SiteConfig{URL: srv.URL})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for requests.Load() < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// Keep advancing until the retry's timer has been registered and fired
+	for {
+		clock.Advance(time.Hour)
+		select {
+		case result := <-done:
+			if got := requests.Load(); got != 2 {
+				t.Errorf("server saw %d requests, want 2", got)
+			}
+			if result.Status != "failed" {
+				t.Errorf("status = %q, want failed", result.Status)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("retry never ran")
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written in config files as a string such as
+// "5s" or "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// String formats the duration like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Config is the monitor configuration loaded from a file. Fields in its
+// "defaults" object apply to every site that doesn't set them itself.
+type Config struct {
+	Sites []SiteConfig `json:"sites"`
+
+	// DefaultHeaders are sent with every check unless a site overrides them
+	DefaultHeaders map[string]string `json:"default_headers,omitempty"`
+
+	// Groups are additional monitors run alongside the top-level sites,
+	// each with its own interval and alert destination
+	Groups map[string]GroupConfig `json:"groups,omitempty"`
+}
+
+// GroupConfig configures an independent group of sites served under
+// /ping/{group}
+type GroupConfig struct {
+	Sites []SiteConfig `json:"sites"`
+
+	// DefaultHeaders are sent with every check in the group
+	DefaultHeaders map[string]string `json:"default_headers,omitempty"`
+
+	// Interval is how often the group's sites are checked; 0 uses the
+	// default of 2m
+	Interval Duration `json:"interval,omitempty"`
+
+	// AlertWebhook overrides the -alert-webhook destination for the group
+	AlertWebhook string `json:"alert_webhook,omitempty"`
+}
+
+// LoadConfig reads and validates a JSON configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	data, err = interpolateEnv(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := applyDefaults(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Sites, err = expandRanges(cfg.Sites); err != nil {
+		return nil, err
+	}
+	for name, group := range cfg.Groups {
+		if group.Sites, err = expandRanges(group.Sites); err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+		cfg.Groups[name] = group
+	}
+
+	if err := validateSites(cfg.Sites); err != nil {
+		return nil, err
+	}
+	for name, group := range cfg.Groups {
+		if name == "" || strings.ContainsAny(name, "/?#") {
+			return nil, fmt.Errorf("invalid group name %q", name)
+		}
+		if group.Interval < 0 {
+			return nil, fmt.Errorf("group %s: invalid interval %s", name, group.Interval)
+		}
+		if err := validateSites(group.Sites); err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// AllSites returns the sites of the config and all of its groups
+func (cfg *Config) AllSites() []SiteConfig {
+	sites := append([]SiteConfig(nil), cfg.Sites...)
+	for _, group := range cfg.Groups {
+		sites = append(sites, group.Sites...)
+	}
+	return sites
+}
+
+// rangePattern matches a numeric range such as {1..50} in a site URL
+var rangePattern = regexp.MustCompile(`\{(\d+)\.\.(\d+)\}`)
+
+// maxRangeSites limits how many sites a single URL pattern may expand to
+const maxRangeSites = 1000
+
+// expandRanges replaces each site whose URL contains numeric ranges, such
+// as https://shard-{1..50}.example.com, with one site per value. Several
+// ranges in one URL expand to every combination. A start with leading
+// zeros, as in {01..10}, pads the values to its width.
+func expandRanges(sites []SiteConfig) ([]SiteConfig, error) {
+	var out []SiteConfig
+	for _, site := range sites {
+		urls, err := expandRange(site.URL)
+		if err != nil {
+			return nil, fmt.Errorf("site %s: %w", site.URL, err)
+		}
+		if len(urls) > maxRangeSites {
+			return nil, fmt.Errorf("site %s: expands to more than %d sites", site.URL, maxRangeSites)
+		}
+		for _, u := range urls {
+			site.URL = u
+			out = append(out, site)
+		}
+	}
+	return out, nil
+}
+
+// expandRange expands the first range in raw and recurses on the results
+func expandRange(raw string) ([]string, error) {
+	loc := rangePattern.FindStringSubmatchIndex(raw)
+	if loc == nil {
+		return []string{raw}, nil
+	}
+
+	first, last := raw[loc[2]:loc[3]], raw[loc[4]:loc[5]]
+	start, err1 := strconv.Atoi(first)
+	end, err2 := strconv.Atoi(last)
+	if err1 != nil || err2 != nil || start > end || end-start >= maxRangeSites {
+		return nil, fmt.Errorf("invalid range {%s..%s}", first, last)
+	}
+	width := 0
+	if len(first) > 1 && first[0] == '0' {
+		width = len(first)
+	}
+
+	rest, err := expandRange(raw[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for n := start; n <= end; n++ {
+		prefix := raw[:loc[0]] + fmt.Sprintf("%0*d", width, n)
+		for _, r := range rest {
+			out = append(out, prefix+r)
+		}
+		if len(out) > maxRangeSites {
+			break
+		}
+	}
+	return out, nil
+}
+
+// validateSites validates each site in a list
+func validateSites(sites []SiteConfig) error {
+	for i := range sites {
+		site := &sites[i]
+		if site.URL == "" {
+			return fmt.Errorf("site %d: url is required", i)
+		}
+		if err := validateSite(site); err != nil {
+			return fmt.Errorf("site %s: %w", site.URL, err)
+		}
+	}
+	return nil
+}
+
+// applyDefaults re-parses each site with the config's "defaults" block
+// underneath it. Merging happens on the raw JSON so any field a site sets
+// explicitly, even to false or zero, wins over the default. Headers are
+// merged by name.
+func applyDefaults(data []byte, cfg *Config) error {
+	var raw struct {
+		Defaults map[string]json.RawMessage   `json:"defaults"`
+		Sites    []map[string]json.RawMessage `json:"sites"`
+		Groups   map[string]struct {
+			Sites []map[string]json.RawMessage `json:"sites"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(raw.Defaults) == 0 {
+		return nil
+	}
+	if _, ok := raw.Defaults["url"]; ok {
+		return fmt.Errorf("defaults may not set url")
+	}
+
+	if err := mergeDefaults(raw.Defaults, raw.Sites, cfg.Sites); err != nil {
+		return err
+	}
+	for name, group := range raw.Groups {
+		if err := mergeDefaults(raw.Defaults, group.Sites, cfg.Groups[name].Sites); err != nil {
+			return fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// mergeDefaults decodes each raw site over defaults into the matching
+// entry of sites
+func mergeDefaults(defaults map[string]json.RawMessage, raw []map[string]json.RawMessage, sites []SiteConfig) error {
+	for i, site := range raw {
+		merged := make(map[string]json.RawMessage, len(defaults)+len(site))
+		for k, v := range defaults {
+			merged[k] = v
+		}
+		for k, v := range site {
+			merged[k] = v
+		}
+
+		if def, ok := defaults["headers"]; ok {
+			if own, ok := site["headers"]; ok {
+				var defHeaders, siteHeaders map[string]string
+				if err := json.Unmarshal(def, &defHeaders); err != nil {
+					return fmt.Errorf("defaults: invalid headers: %w", err)
+				}
+				if err := json.Unmarshal(own, &siteHeaders); err != nil {
+					return fmt.Errorf("site %d: invalid headers: %w", i, err)
+				}
+				merged["headers"], _ = json.Marshal(mergeHeaders(defHeaders, siteHeaders))
+			}
+		}
+
+		encoded, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		var siteCfg SiteConfig
+		if err := json.Unmarshal(encoded, &siteCfg); err != nil {
+			return fmt.Errorf("site %d: %w", i, err)
+		}
+		sites[i] = siteCfg
+	}
+
+	return nil
+}
+
+// validateSite checks a site's settings and compiles its patterns
+func validateSite(site *SiteConfig) error {
+	if site.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if err := site.compile(); err != nil {
+		return err
+	}
+	if site.SourceIP != "" {
+		if err := ValidateSourceIP(site.SourceIP); err != nil {
+			return err
+		}
+	}
+	if site.Proxy != "" {
+		u, err := url.Parse(site.Proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return fmt.Errorf("invalid proxy %q: must be an http, https or socks5 URL", site.Proxy)
+		}
+	}
+	if site.MinTLSVersion != "" {
+		if _, err := parseTLSVersion(site.MinTLSVersion); err != nil {
+			return err
+		}
+	}
+	for _, rule := range site.LatencySLA {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid latency_sla: %w", err)
+		}
+	}
+	if site.AlertAfterFailures < 0 || site.RecoverAfterSuccesses < 0 {
+		return fmt.Errorf("alert thresholds must not be negative")
+	}
+	if site.ConnectTimeout < 0 {
+		return fmt.Errorf("invalid connect_timeout %s", site.ConnectTimeout)
+	}
+	for code, status := range site.StatusMap {
+		if code < 100 || code > 599 || status == "" {
+			return fmt.Errorf("invalid status_map entry %d: %q", code, status)
+		}
+	}
+	if site.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %s", site.Timeout)
+	}
+	if site.RequestTimeout < 0 {
+		return fmt.Errorf("invalid request_timeout %s", site.RequestTimeout)
+	}
+	if site.WarmupTimeout < 0 || (site.WarmupTimeout > 0 && site.Timeout > 0 && site.WarmupTimeout <= site.Timeout) {
+		return fmt.Errorf("invalid warmup_timeout %s: must be longer than the timeout", site.WarmupTimeout)
+	}
+	if site.MaxRedirects < 0 {
+		return fmt.Errorf("invalid max_redirects %d", site.MaxRedirects)
+	}
+	if site.Port < 0 || site.Port > 65535 {
+		return fmt.Errorf("invalid port %d", site.Port)
+	}
+	if site.Type() == CheckExec && len(site.Command) == 0 {
+		return fmt.Errorf("exec checks require a command")
+	}
+	if site.AssertionMode != "" && site.AssertionMode != AssertAll && site.AssertionMode != AssertAny {
+		return fmt.Errorf("invalid assertion_mode %q: must be all or any", site.AssertionMode)
+	}
+	if site.MinBytes < 0 || site.MaxBytes < 0 || site.MaxBytes >= maxBodyBytes || (site.MaxBytes > 0 && site.MinBytes > site.MaxBytes) {
+		return fmt.Errorf("invalid body size bounds min_bytes %d, max_bytes %d: max_bytes must be below %d", site.MinBytes, site.MaxBytes, maxBodyBytes)
+	}
+	if (site.MinBytes > 0 || site.MaxBytes > 0) && site.Type() != CheckHTTP {
+		return fmt.Errorf("min_bytes and max_bytes are only supported for http checks")
+	}
+	if site.SlowThresholdMs < 0 || (site.Timeout > 0 && site.slowThreshold() >= time.Duration(site.Timeout)) {
+		return fmt.Errorf("invalid slow_threshold_ms %v: must be below the timeout", site.SlowThresholdMs)
+	}
+	if site.MaxTTFBMs < 0 {
+		return fmt.Errorf("invalid max_ttfb_ms %v", site.MaxTTFBMs)
+	}
+	if site.MaxTTFBMs > 0 && site.Type() != CheckHTTP {
+		return fmt.Errorf("max_ttfb_ms is only supported for http checks")
+	}
+	if site.DNSRecordType != "" || len(site.DNSExpect) > 0 {
+		if site.Type() != CheckDNS {
+			return fmt.Errorf("dns_record_type and dns_expect are only supported for dns checks")
+		}
+		if site.DNSRecordType != "" && !dnsRecordTypes[strings.ToUpper(site.DNSRecordType)] {
+			return fmt.Errorf("invalid dns_record_type %q: must be A, AAAA, CNAME, MX, NS or TXT", site.DNSRecordType)
+		}
+	}
+	if (site.ServerName != "" || site.HostHeader != "") && site.Type() != CheckHTTP {
+		return fmt.Errorf("server_name and host_header are only supported for http checks")
+	}
+	if site.ExpectHTTPSUpgrade {
+		if target, err := normalizeURL(*site); site.Type() != CheckHTTP || err != nil || !strings.HasPrefix(target, "http://") {
+			return fmt.Errorf("expect_https_upgrade is only supported for http checks of http:// URLs")
+		}
+	}
+	if len(site.ExpectBodyAbsent) > 0 && site.Type() != CheckHTTP {
+		return fmt.Errorf("expect_body_absent is only supported for http checks")
+	}
+	if slices.Contains(site.ExpectBodyAbsent, "") {
+		return fmt.Errorf("expect_body_absent must not contain empty strings")
+	}
+	if site.Severity != "" {
+		if err := ValidateSeverity(site.Severity); err != nil {
+			return err
+		}
+	}
+	if len(site.RetryOnStatus) > 0 && site.Type() != CheckHTTP {
+		return fmt.Errorf("retry_on_status is only supported for http checks")
+	}
+	for _, code := range site.RetryOnStatus {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("invalid retry_on_status %d: must be an HTTP error status (400-599)", code)
+		}
+	}
+	if site.CaptureHeaders && site.Type() != CheckHTTP {
+		return fmt.Errorf("capture_headers is only supported for http checks")
+	}
+	if site.HTTP10 && site.Type() != CheckHTTP {
+		return fmt.Errorf("http10 is only supported for http checks")
+	}
+	if len(site.Paths) > 0 && site.Type() != CheckHTTP {
+		return fmt.Errorf("paths are only supported for http checks")
+	}
+	if site.Type() == CheckHTTP || site.Type() == CheckHTTP3 {
+		if _, err := normalizeURL(*site); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redacted replaces secret values in config output
+const redacted = "REDACTED"
+
+// secretNames matches header and query parameter names whose values are
+// redacted
+var secretNames = regexp.MustCompile(`(?i)^(authorization|proxy-authorization|cookie)$|token|secret|password|api[-_]?key`)
+
+// EffectiveConfig is the configuration the monitor is actually running
+// with, after defaults are applied
+type EffectiveConfig struct {
+	Interval Duration     `json:"interval"`
+	Sites    []SiteConfig `json:"sites"`
+}
+
+// EffectiveConfig returns each site's resolved settings with secrets redacted
+func (wm *WebsiteMonitor) EffectiveConfig() EffectiveConfig {
+	cfg := EffectiveConfig{Interval: Duration(wm.Interval)}
+	for _, site := range wm.Sites() {
+		site = wm.effectiveSite(site)
+		site.CheckType = site.Type()
+		if site.Timeout == 0 {
+			site.Timeout = Duration(defaultCheckTimeout)
+		}
+		if site.Type() == CheckHTTP && site.MaxRedirects == 0 {
+			site.MaxRedirects = defaultMaxRedirects
+		}
+		cfg.Sites = append(cfg.Sites, redactSite(site))
+	}
+	return cfg
+}
+
+// redactSite hides credentials in a site's URL and sensitive headers and
+// query parameters
+func redactSite(site SiteConfig) SiteConfig {
+	site.URL = redactURL(site.URL)
+	if site.Proxy != "" {
+		site.Proxy = redactURL(site.Proxy)
+	}
+	site.Headers = redactValues(site.Headers)
+	site.Query = redactValues(site.Query)
+	return site
+}
+
+// redactValues returns a copy of values with secret-looking entries hidden
+func redactValues(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return values
+	}
+	redactedValues := make(map[string]string, len(values))
+	for name, value := range values {
+		if secretNames.MatchString(name) {
+			value = redacted
+		}
+		redactedValues[name] = value
+	}
+	return redactedValues
+}
+
+// redactURL hides the password and secret-looking query parameters in raw
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if secretNames.MatchString(name) {
+				query[name] = []string{redacted}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// envPattern matches ${VAR} and ${VAR:-default} references
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv replaces environment variable references in the config
+// with their values, escaped for use inside JSON strings. Referencing an
+// unset variable without a default is an error.
+func interpolateEnv(data []byte) ([]byte, error) {
+	var missing []string
+
+	out := envPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := envPattern.FindSubmatch(match)
+		name := string(groups[1])
+
+		value, ok := os.LookupEnv(name)
+		if !ok || (value == "" && groups[2] != nil) {
+			if groups[2] == nil {
+				missing = append(missing, name)
+				return match
+			}
+			value = string(groups[3])
+		}
+
+		escaped, _ := json.Marshal(value)
+		return escaped[1 : len(escaped)-1]
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("config references unset environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	return out, nil
+}
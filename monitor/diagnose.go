@@ -0,0 +1,224 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DiagnosticStep is the outcome of one stage of a diagnosis
+type DiagnosticStep struct {
+	Step     string `json:"step"`
+	OK       bool   `json:"ok"`
+	Duration string `json:"duration,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// Headers holds the response headers of the http step
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Diagnosis is a step-by-step report of reaching a site: DNS resolution,
+// TCP connect, TLS handshake and an HTTP request. It stops at the first
+// failing step.
+type Diagnosis struct {
+	Site     string           `json:"site"`
+	OK       bool             `json:"ok"`
+	FailedAt string           `json:"failed_at,omitempty"`
+	Steps    []DiagnosticStep `json:"steps"`
+}
+
+// Diagnose walks through each stage of an HTTP check of site separately.
+// Sites with a proxy are diagnosed through it, with DNS and TCP steps for
+// the proxy and a proxy step for the tunnel; proxyAuth is sent when the
+// proxy URL has no credentials of its own. Response headers named in
+// redactHeaders, or DefaultRedactedHeaders when it is nil, are hidden in
+// the report.
+func Diagnose(ctx context.Context, site SiteConfig, redactHeaders []string, proxyAuth *url.Userinfo) Diagnosis {
+	d := Diagnosis{Site: site.URL}
+
+	target, err := normalizeURL(site)
+	if err != nil {
+		d.fail(DiagnosticStep{Step: "url", Error: err.Error()})
+		return d
+	}
+	u, _ := url.Parse(target)
+
+	var proxy *url.URL
+	if site.Proxy != "" {
+		if proxy, err = url.Parse(site.Proxy); err != nil || proxy.Scheme != "http" {
+			d.fail(DiagnosticStep{Step: "proxy", Error: fmt.Sprintf("unsupported proxy %s: only http proxies can be diagnosed", redactURL(site.Proxy))})
+			return d
+		}
+		if proxy.User == nil {
+			proxy.User = proxyAuth
+		}
+	}
+
+	// Without a proxy the DNS and TCP steps are for the site itself
+	dial := u
+	if proxy != nil {
+		dial = proxy
+	}
+	port := dial.Port()
+	if port == "" {
+		port = defaultPorts[dial.Scheme]
+	}
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, dial.Hostname())
+	if err != nil {
+		d.fail(DiagnosticStep{Step: "dns", Error: err.Error()})
+		return d
+	}
+	ips := make([]string, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.String()
+	}
+	d.pass("dns", start, fmt.Sprintf("%s resolved to %s", dial.Hostname(), strings.Join(ips, ", ")))
+
+	start = time.Now()
+	conn, err := siteDialer(site).DialContext(ctx, "tcp", net.JoinHostPort(addrs[0].IP.String(), port))
+	if err != nil {
+		d.fail(DiagnosticStep{Step: "tcp", Error: err.Error()})
+		return d
+	}
+	defer conn.Close()
+	d.pass("tcp", start, fmt.Sprintf("connected to %s", conn.RemoteAddr()))
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// HTTPS goes through a CONNECT tunnel; plain HTTP requests are sent to
+	// the proxy in absolute form below
+	if proxy != nil && u.Scheme == "https" {
+		start = time.Now()
+		if err := connectTunnel(conn, proxy, u); err != nil {
+			d.fail(DiagnosticStep{Step: "proxy", Error: err.Error()})
+			return d
+		}
+		d.pass("proxy", start, fmt.Sprintf("tunnel to %s through %s", u.Host, proxy.Host))
+	}
+
+	if u.Scheme == "https" {
+		start = time.Now()
+		min, _ := parseTLSVersion(site.MinTLSVersion)
+		serverName := u.Hostname()
+		if site.ServerName != "" {
+			serverName = site.ServerName
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, MinVersion: min})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			d.fail(DiagnosticStep{Step: "tls", Error: err.Error()})
+			return d
+		}
+		state := tlsConn.ConnectionState()
+		detail := tls.VersionName(state.Version)
+		if len(state.PeerCertificates) > 0 {
+			cert := state.PeerCertificates[0]
+			detail += fmt.Sprintf(", certificate for %s expires %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+		}
+		d.pass("tls", start, detail)
+		conn = tlsConn
+	}
+
+	start = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		d.fail(DiagnosticStep{Step: "http", Error: err.Error()})
+		return d
+	}
+	setHeaders(req, site.Headers)
+	if site.HostHeader != "" {
+		req.Host = site.HostHeader
+	}
+	req.Close = true
+	write := req.Write
+	if proxy != nil && u.Scheme == "http" {
+		if proxy.User != nil {
+			req.Header.Set("Proxy-Authorization", basicAuth(proxy.User))
+		}
+		write = req.WriteProxy
+	}
+	if err := write(conn); err != nil {
+		d.fail(DiagnosticStep{Step: "http", Error: err.Error()})
+		return d
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		d.fail(DiagnosticStep{Step: "http", Error: err.Error()})
+		return d
+	}
+	resp.Body.Close()
+	headers := captureHeaders(resp.Header, redactHeaders)
+	if resp.StatusCode >= http.StatusBadRequest {
+		d.fail(DiagnosticStep{
+			Step:     "http",
+			Duration: formatDuration(time.Since(start)),
+			Error:    fmt.Sprintf("Unexpected status: %s", resp.Status),
+			Headers:  headers,
+		})
+		return d
+	}
+	d.pass("http", start, resp.Status)
+	d.Steps[len(d.Steps)-1].Headers = headers
+
+	d.OK = true
+	return d
+}
+
+// connectTunnel asks proxy to open a tunnel to target over conn
+func connectTunnel(conn net.Conn, proxy, target *url.URL) error {
+	port := target.Port()
+	if port == "" {
+		port = defaultPorts[target.Scheme]
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: net.JoinHostPort(target.Hostname(), port)},
+		Host:   net.JoinHostPort(target.Hostname(), port),
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		req.Header.Set("Proxy-Authorization", basicAuth(proxy.User))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	// The server sends nothing through the tunnel before the TLS handshake
+	// starts, so the buffered reader can't swallow any of it
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused the tunnel: %s", resp.Status)
+	}
+	return nil
+}
+
+// basicAuth formats user as a Basic authorization value
+func basicAuth(user *url.Userinfo) string {
+	password, _ := user.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password))
+}
+
+// pass records a successful step that began at start
+func (d *Diagnosis) pass(step string, start time.Time, detail string) {
+	d.Steps = append(d.Steps, DiagnosticStep{Step: step, OK: true, Duration: formatDuration(time.Since(start)), Detail: detail})
+}
+
+// fail records the failing step that ends the diagnosis
+func (d *Diagnosis) fail(step DiagnosticStep) {
+	d.Steps = append(d.Steps, step)
+	d.FailedAt = step.Step
+}
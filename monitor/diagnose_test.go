@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testProxy is a forward proxy that relays plain requests and CONNECT
+// tunnels, counting the requests it sees with the expected credentials
+func testProxy(t *testing.T, wantAuth string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var authorized atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != wantAuth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		authorized.Add(1)
+
+		if r.Method != http.MethodConnect {
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &authorized
+}
+
+func TestDiagnoseThroughProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	tlsTarget := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsTarget.Close()
+
+	proxy, authorized := testProxy(t, basicAuth(url.UserPassword("monitor", "s3cret")))
+
+	tests := []struct {
+		name      string
+		url       string
+		wantSteps []string
+		wantOK    bool
+	}{
+		{"http", target.URL, []string{"dns", "tcp", "http"}, true},
+		// The test server's certificate isn't trusted, so the tunnel works
+		// but the handshake fails
+		{"https", tlsTarget.URL, []string{"dns", "tcp", "proxy", "tls"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := authorized.Load()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			d := Diagnose(ctx, SiteConfig{URL: tt.url, Proxy: proxy.URL}, nil, url.UserPassword("monitor", "s3cret"))
+			var steps []string
+			for _, step := range d.Steps {
+				steps = append(steps, step.Step)
+			}
+			if d.OK != tt.wantOK || len(steps) != len(tt.wantSteps) {
+				t.Fatalf("steps %v (ok %v, %+v), want %v (ok %v)", steps, d.OK, d.Steps, tt.wantSteps, tt.wantOK)
+			}
+			for i := range steps {
+				if steps[i] != tt.wantSteps[i] {
+					t.Fatalf("steps %v, want %v", steps, tt.wantSteps)
+				}
+			}
+			if authorized.Load() == before {
+				t.Error("diagnosis didn't go through the proxy with its credentials")
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxExecOutput caps how much command output is kept in a result
+const maxExecOutput = 4096
+
+// ExecChecker runs a site's command, treating exit status 0 as success.
+// It runs arbitrary programs, so it is only registered with -allow-exec.
+type ExecChecker struct{}
+
+// Check runs the command until it exits or the check times out
+func (ExecChecker) Check(ctx context.Context, site SiteConfig) PingResult {
+	if len(site.Command) == 0 {
+		return deterministicFailure("No command configured")
+	}
+
+	var output cappedBuffer
+	cmd := exec.CommandContext(ctx, site.Command[0], site.Command[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	duration := time.Since(start)
+
+	var result PingResult
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result = successResult(duration)
+	case ctx.Err() != nil:
+		result = retryableFailure(fmt.Sprintf("Command timed out: %v", ctx.Err()))
+	case errors.As(err, &exitErr):
+		result = failedResult(fmt.Sprintf("Command exited with status %d", exitErr.ExitCode()))
+	default:
+		result = deterministicFailure(fmt.Sprintf("Failed to run command: %v", err))
+	}
+	result.Output = strings.TrimSpace(output.String())
+
+	return result
+}
+
+// cappedBuffer keeps the first maxExecOutput bytes written to it and
+// discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxExecOutput - b.Len(); room < len(p) {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.Buffer.String() + "... (truncated)"
+	}
+	return b.Buffer.String()
+}
@@ -0,0 +1,162 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// snapshot is the structure of a response: its status code and, for JSON
+// bodies, the type found at each path. Values are ignored so volatile data
+// doesn't count as a change.
+type snapshot struct {
+	StatusCode int
+	Shape      map[string]string
+}
+
+// newSnapshot describes a response's structure
+func newSnapshot(statusCode int, body []byte) snapshot {
+	s := snapshot{StatusCode: statusCode}
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		s.Shape = make(map[string]string)
+		collectShape(s.Shape, "", v)
+	}
+	return s
+}
+
+// collectShape records the JSON type of v at path and of everything below
+// it. Array elements share the path "name[]".
+func collectShape(shape map[string]string, path string, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		shape[path] = "object"
+		for key, child := range v {
+			collectShape(shape, joinPath(path, key), child)
+		}
+	case []any:
+		shape[path] = "array"
+		for _, child := range v {
+			collectShape(shape, path+"[]", child)
+		}
+	case string:
+		shape[path] = "string"
+	case float64:
+		shape[path] = "number"
+	case bool:
+		shape[path] = "boolean"
+	case nil:
+		shape[path] = "null"
+	}
+}
+
+// joinPath appends key to a dotted path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// diff lists the structural differences of s from golden, skipping paths
+// that are, or sit under, an ignored path
+func (s snapshot) diff(golden snapshot, ignore []string) []string {
+	var diffs []string
+	if s.StatusCode != golden.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("status %d, golden %d", s.StatusCode, golden.StatusCode))
+	}
+	if golden.Shape != nil && s.Shape == nil {
+		return append(diffs, "body is no longer JSON")
+	}
+
+	ignored := func(path string) bool {
+		for _, prefix := range ignore {
+			if path == prefix || strings.HasPrefix(path, prefix+".") || strings.HasPrefix(path, prefix+"[]") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var paths []string
+	for path := range golden.Shape {
+		paths = append(paths, path)
+	}
+	for path := range s.Shape {
+		if _, ok := golden.Shape[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if ignored(path) {
+			continue
+		}
+		want, inGolden := golden.Shape[path]
+		got, inResponse := s.Shape[path]
+		name := path
+		if name == "" {
+			name = "(root)"
+		}
+		switch {
+		case !inResponse:
+			diffs = append(diffs, fmt.Sprintf("missing %s", name))
+		case !inGolden:
+			diffs = append(diffs, fmt.Sprintf("unexpected %s", name))
+		case got != want:
+			diffs = append(diffs, fmt.Sprintf("%s is %s, golden %s", name, got, want))
+		}
+	}
+	return diffs
+}
+
+// compareGolden remembers target's latest snapshot and compares it to the
+// captured golden, if there is one
+func (c *HTTPChecker) compareGolden(target string, s snapshot, ignore []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshots == nil {
+		c.snapshots = make(map[string]snapshot)
+	}
+	c.snapshots[target] = s
+
+	golden, ok := c.goldens[target]
+	if !ok {
+		return nil
+	}
+	return s.diff(golden, ignore)
+}
+
+// CaptureGolden promotes the latest response seen from each target
+// matching host to its golden, returning how many were captured
+func (c *HTTPChecker) CaptureGolden(host string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.goldens == nil {
+		c.goldens = make(map[string]snapshot)
+	}
+
+	captured := 0
+	for target, s := range c.snapshots {
+		if matchesHost(target, host) {
+			c.goldens[target] = s
+			captured++
+		}
+	}
+	return captured
+}
+
+// CaptureGolden makes the latest responses of the sites matching host
+// their golden snapshots. Only sites with compare_to_golden set are
+// snapshotted, and only once they have been checked.
+func (wm *WebsiteMonitor) CaptureGolden(host string) int {
+	wm.mu.RLock()
+	checker, ok := wm.checkers[CheckHTTP].(*HTTPChecker)
+	wm.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return checker.CaptureGolden(host)
+}
@@ -0,0 +1,662 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// apiError is the JSON body of every API error response
+type apiError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// WriteError responds with status and a JSON body describing the error
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: msg, Code: status})
+}
+
+// SiteResult pairs a site with its latest result
+type SiteResult struct {
+	Site   string
+	Result PingResult
+}
+
+// orderedResults encodes as a JSON object whose keys keep slice order
+type orderedResults []SiteResult
+
+// MarshalJSON writes the results as {"site": result, ...} in order
+func (o orderedResults) MarshalJSON() ([]byte, error) {
+	return pingResponse{Results: o}.MarshalJSON()
+}
+
+// pingResponse is the /ping body: the overall status, whether monitoring
+// is paused and, when paginated, the total number of sites, followed by
+// each site's result. Pushed snapshots may also carry each site's recent
+// history.
+type pingResponse struct {
+	Overall string
+	Paused  bool
+	Total   *int
+	History map[string][]Sample
+	Results orderedResults
+}
+
+// MarshalJSON writes {"overall": ..., "paused": true, "total": ...,
+// "history": ..., "site": result, ...}, omitting overall when it is empty,
+// paused when false and total and history when they are nil
+func (p pingResponse) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	field := func(key string, value any) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+		return nil
+	}
+
+	if p.Overall != "" {
+		if err := field("overall", p.Overall); err != nil {
+			return nil, err
+		}
+	}
+	if p.Paused {
+		if err := field("paused", true); err != nil {
+			return nil, err
+		}
+	}
+	if p.Total != nil {
+		if err := field("total", *p.Total); err != nil {
+			return nil, err
+		}
+	}
+	if p.History != nil {
+		if err := field("history", p.History); err != nil {
+			return nil, err
+		}
+	}
+	for _, sr := range p.Results {
+		if err := field(sr.Site, sr.Result); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// sortResults orders results by site, status or latency. Ties are broken by
+// site so the order is always deterministic.
+func sortResults(results map[string]PingResult, by string) ([]SiteResult, error) {
+	list := make([]SiteResult, 0, len(results))
+	for site, result := range results {
+		list = append(list, SiteResult{Site: site, Result: result})
+	}
+
+	var less func(a, b SiteResult) bool
+	switch by {
+	case "", "site":
+		less = func(a, b SiteResult) bool { return false }
+	case "status":
+		less = func(a, b SiteResult) bool { return a.Result.Status < b.Result.Status }
+	case "latency":
+		// Results without a measured latency sort last
+		less = func(a, b SiteResult) bool {
+			da, db := a.Result.Duration, b.Result.Duration
+			if da == 0 || db == 0 {
+				return da != 0 && db == 0
+			}
+			return da < db
+		}
+	default:
+		return nil, fmt.Errorf("invalid sort %q: must be site, status or latency", by)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Site < b.Site
+	})
+
+	return list, nil
+}
+
+// PingHandler serves the current results as JSON, ordered by ?sort=,
+// along with their overall status. ?fresh=true re-checks stale sites first.
+func PingHandler(monitor *WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plain := strings.HasPrefix(r.Header.Get("Accept"), "text/plain")
+		if monitor.PingCacheTTL > 0 && r.URL.RawQuery == "" && !plain {
+			body, overall := monitor.cachedPing()
+			w.Header().Set("X-Overall-Status", overall)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+
+		refreshIfRequested(monitor, r)
+		results := monitor.GetResults()
+		list, err := sortResults(results, r.URL.Query().Get("sort"))
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		total := len(list)
+		list, paginated, err := paginate(list, r.URL.Query())
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		overall := overallStatus(results, monitor.Overall)
+		w.Header().Set("X-Overall-Status", overall)
+
+		if plain {
+			writeResultsTable(w, list, monitor.Clock.Now(), monitor.Location)
+			return
+		}
+
+		resp := pingResponse{Overall: overall, Paused: monitor.Paused(), Results: list}
+		if paginated {
+			resp.Total = &total
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// ndjsonFlushLines is how many lines /ping.ndjson writes between flushes
+const ndjsonFlushLines = 100
+
+// ndjsonResult is one line of /ping.ndjson
+type ndjsonResult struct {
+	Site string `json:"site"`
+	PingResult
+}
+
+// PingNDJSONHandler streams the current results as newline-delimited
+// JSON, one site per line, so large site lists can be consumed without
+// either side holding the whole document. The results are a single
+// snapshot; the overall status is sent in X-Overall-Status. It supports
+// the same ?sort=, ?limit=, ?offset= and ?fresh= as /ping.
+func PingNDJSONHandler(monitor *WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refreshIfRequested(monitor, r)
+		results := monitor.GetResults()
+		list, err := sortResults(results, r.URL.Query().Get("sort"))
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		list, _, err = paginate(list, r.URL.Query())
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		w.Header().Set("X-Overall-Status", overallStatus(results, monitor.Overall))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		for i, sr := range list {
+			if err := enc.Encode(ndjsonResult{Site: sr.Site, PingResult: sr.Result}); err != nil {
+				return
+			}
+			if (i+1)%ndjsonFlushLines == 0 {
+				rc.Flush()
+			}
+		}
+	}
+}
+
+// pingCache holds the serialized response to a plain GET /ping
+type pingCache struct {
+	mu      sync.Mutex
+	version uint64
+	expires time.Time
+	overall string
+	body    []byte
+}
+
+// cachedPing returns the body and overall status of a plain /ping
+// response. The results are only serialized again once they have changed
+// or the cached copy is older than PingCacheTTL; concurrent requests for a
+// stale copy wait for a single serialization.
+func (wm *WebsiteMonitor) cachedPing() ([]byte, string) {
+	// The version is read first so results that change during
+	// serialization leave the copy marked stale
+	wm.mu.RLock()
+	version := wm.version
+	wm.mu.RUnlock()
+
+	c := &wm.pingCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := wm.Clock.Now()
+	if c.body != nil && c.version == version && now.Before(c.expires) {
+		return c.body, c.overall
+	}
+
+	results := wm.GetResults()
+	list, _ := sortResults(results, "site")
+	overall := overallStatus(results, wm.Overall)
+	body, err := json.Marshal(pingResponse{Overall: overall, Paused: wm.Paused(), Results: list})
+	if err != nil {
+		log.Printf("Failed to encode results: %v", err)
+		return []byte("{}\n"), overall
+	}
+
+	c.version, c.expires, c.overall, c.body = version, now.Add(wm.PingCacheTTL), overall, append(body, '\n')
+	return c.body, c.overall
+}
+
+// paginate applies ?offset= and ?limit= to a sorted result list, reporting
+// whether either was given. Without them the whole list is returned.
+func paginate(list []SiteResult, query url.Values) ([]SiteResult, bool, error) {
+	if !query.Has("limit") && !query.Has("offset") {
+		return list, false, nil
+	}
+
+	offset, limit := 0, len(list)
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, false, fmt.Errorf("invalid offset %q: must be a non-negative integer", v)
+		}
+		offset = min(n, len(list))
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, false, fmt.Errorf("invalid limit %q: must be a positive integer", v)
+		}
+		limit = n
+	}
+
+	// Clamp the limit first so offset+limit can't overflow
+	return list[offset : offset+min(limit, len(list)-offset)], true, nil
+}
+
+// GroupPingHandler serves the results of the monitor group named by the
+// {group} path value
+func GroupPingHandler(groups map[string]*WebsiteMonitor) http.HandlerFunc {
+	return GroupHandler(groups, PingHandler)
+}
+
+// GroupHandler serves handler for the monitor group named by the {group}
+// path value
+func GroupHandler(groups map[string]*WebsiteMonitor, handler func(*WebsiteMonitor) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("group")
+		monitor, ok := groups[name]
+		if !ok {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("Unknown group %q", name))
+			return
+		}
+		handler(monitor)(w, r)
+	}
+}
+
+// refreshIfRequested re-checks stale sites before responding when the
+// request asks for ?fresh=true
+func refreshIfRequested(monitor *WebsiteMonitor, r *http.Request) {
+	if r.URL.Query().Get("fresh") != "true" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), monitor.FreshTimeout)
+	defer cancel()
+	monitor.Refresh(ctx, monitor.FreshnessWindow)
+}
+
+// PingTextHandler serves the current results as a plaintext table
+func PingTextHandler(monitor *WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refreshIfRequested(monitor, r)
+		list, err := sortResults(monitor.GetResults(), r.URL.Query().Get("sort"))
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		list, _, err = paginate(list, r.URL.Query())
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeResultsTable(w, list, monitor.Clock.Now(), monitor.Location)
+	}
+}
+
+// writeResultsTable renders results as an aligned table for terminals, with
+// check times shown in loc
+func writeResultsTable(w http.ResponseWriter, list []SiteResult, now time.Time, loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SITE\tSTATUS\tLOSS\tAVG\tCHECKED\tAGE")
+	for _, sr := range list {
+		checked, age := "-", "-"
+		if !sr.Result.CheckedAt.IsZero() {
+			checked = sr.Result.CheckedAt.In(loc).Format("2006-01-02 15:04:05 MST")
+			age = now.Sub(sr.Result.CheckedAt).Round(time.Second).String()
+		}
+		site := sr.Site
+		if sr.Result.Name != "" {
+			site = sr.Result.Name
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", site, sr.Result.Status,
+			orDash(sr.Result.Loss), orDash(sr.Result.AvgTime), checked, age)
+	}
+	tw.Flush()
+}
+
+// orDash substitutes a dash for empty table cells
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// StatsHandler serves the monitor's internal statistics
+func StatsHandler(monitor *WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(monitor.Stats())
+	}
+}
+
+// diagnoseTimeout bounds a whole diagnosis
+const diagnoseTimeout = 15 * time.Second
+
+// DiagnoseHandler runs a step-by-step diagnosis of the HTTP site matching
+// the {host} path value in any of the monitors
+func DiagnoseHandler(monitors []*WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.PathValue("host")
+
+		var monitor *WebsiteMonitor
+		var site SiteConfig
+	search:
+		for _, m := range monitors {
+			for _, s := range m.Sites() {
+				if matchesHost(s.URL, host) {
+					monitor, site = m, s
+					break search
+				}
+			}
+		}
+		if monitor == nil {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("No site matches %q", host))
+			return
+		}
+		if site.Type() != CheckHTTP {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("Diagnosis is only supported for http checks, %s is %s", site.URL, site.Type()))
+			return
+		}
+
+		// Hide the same headers and use the same proxy credentials as the
+		// monitor's HTTP checks
+		var redactHeaders []string
+		var proxyAuth *url.Userinfo
+		monitor.mu.RLock()
+		if hc, ok := monitor.checkers[CheckHTTP].(*HTTPChecker); ok {
+			redactHeaders, proxyAuth = hc.RedactHeaders, hc.ProxyAuth
+		}
+		monitor.mu.RUnlock()
+
+		// A hanging site takes the whole diagnosis timeout, which may be
+		// longer than the server's write timeout allows
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(diagnoseTimeout + 5*time.Second))
+		ctx, cancel := context.WithTimeout(r.Context(), diagnoseTimeout)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Diagnose(ctx, monitor.effectiveSite(site), redactHeaders, proxyAuth))
+	}
+}
+
+// ConfigHandler serves the effective per-site configuration
+func ConfigHandler(monitor *WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(monitor.EffectiveConfig())
+	}
+}
+
+// AddSiteHandler starts monitoring the site described by the JSON body
+func AddSiteHandler(monitor *WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var site SiteConfig
+		if err := json.NewDecoder(r.Body).Decode(&site); err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("Invalid site: %v", err))
+			return
+		}
+		if err := monitor.AddSite(site); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(site)
+	}
+}
+
+// NoteHandler sets the note on sites matching the {host} path value in any
+// of the monitors from a {"note": "..."} body. DELETE, or an empty note,
+// clears it.
+func NoteHandler(monitors []*WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.PathValue("host")
+
+		var body struct {
+			Note string `json:"note"`
+		}
+		if r.Method != http.MethodDelete {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				WriteError(w, http.StatusBadRequest, fmt.Sprintf("Invalid note: %v", err))
+				return
+			}
+		}
+
+		updated := 0
+		for _, m := range monitors {
+			updated += m.SetNote(host, body.Note)
+		}
+		if updated == 0 {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("No site matches %q", host))
+			return
+		}
+		log.Printf("Set note on %d site(s) for %q: %q", updated, host, body.Note)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+	}
+}
+
+// GoldenHandler captures the latest responses of the sites matching the
+// {host} path value in any of the monitors as their golden snapshots
+func GoldenHandler(monitors []*WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.PathValue("host")
+		captured := 0
+		for _, m := range monitors {
+			captured += m.CaptureGolden(host)
+		}
+		if captured == 0 {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("No checked site with compare_to_golden matches %q", host))
+			return
+		}
+		log.Printf("Captured %d golden snapshot(s) for %q", captured, host)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"captured": captured})
+	}
+}
+
+// ImportHistoryHandler seeds site histories from a JSON object mapping
+// each site to an array of samples. Each site's samples go to the monitor
+// that checks it, and nothing is imported unless every site validates.
+func ImportHistoryHandler(monitors []*WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var samples map[string][]Sample
+		if err := json.NewDecoder(r.Body).Decode(&samples); err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("Invalid history: %v", err))
+			return
+		}
+
+		owners := make(map[string]*WebsiteMonitor)
+		for _, m := range monitors {
+			for _, site := range m.Sites() {
+				if _, ok := owners[site.URL]; !ok {
+					owners[site.URL] = m
+				}
+			}
+		}
+		split := make(map[*WebsiteMonitor]map[string][]Sample)
+		for site, list := range samples {
+			m, ok := owners[site]
+			if !ok {
+				WriteError(w, http.StatusBadRequest, fmt.Sprintf("unknown site %s", site))
+				return
+			}
+			if split[m] == nil {
+				split[m] = make(map[string][]Sample)
+			}
+			split[m][site] = list
+		}
+		for m, s := range split {
+			if err := m.validateImport(s); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		imported := 0
+		for m, s := range split {
+			n, err := m.ImportHistory(s)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			imported += n
+		}
+		log.Printf("Imported %d history sample(s) for %d site(s)", imported, len(samples))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"imported": imported})
+	}
+}
+
+// ResetHandler clears stored results in all of the monitors for all sites,
+// or the one named by the {host} path value, and reports how many entries
+// were cleared. A route with a {host} wildcard never clears everything; an
+// empty host is rejected.
+func ResetHandler(monitors []*WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.PathValue("host")
+		if host == "" && strings.Contains(r.Pattern, "{host") {
+			WriteError(w, http.StatusBadRequest, "missing host")
+			return
+		}
+		cleared := 0
+		for _, m := range monitors {
+			cleared += m.Reset(host)
+		}
+		log.Printf("Reset %d result(s) for %q", cleared, host)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"cleared": cleared})
+	}
+}
+
+// PauseHandler pauses or, when paused is false, resumes all of the given
+// monitors
+func PauseHandler(monitors []*WebsiteMonitor, paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range monitors {
+			if paused {
+				m.Pause()
+			} else {
+				m.Resume()
+			}
+		}
+		if paused {
+			log.Printf("Paused all monitoring")
+		} else {
+			log.Printf("Resumed all monitoring")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"paused": paused})
+	}
+}
+
+// TestAlertHandler fires a synthetic failure and recovery alert for the
+// sites matching the {host} path value in any of the monitors
+func TestAlertHandler(monitors []*WebsiteMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.PathValue("host")
+		sent := 0
+		for _, m := range monitors {
+			sent += m.TestAlert(host)
+		}
+		if sent == 0 {
+			WriteError(w, http.StatusNotFound, fmt.Sprintf("No site matches %q", host))
+			return
+		}
+		log.Printf("Sent test alerts for %d site(s) matching %q", sent, host)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"sites": sent})
+	}
+}
+
+// matchesHost reports whether a configured site refers to host, either by
+// its exact configured URL or by its hostname
+func matchesHost(site, host string) bool {
+	if site == host {
+		return true
+	}
+
+	raw := site
+	if !strings.Contains(raw, "://") {
+		raw = "//" + raw
+	}
+	u, err := url.Parse(raw)
+	return err == nil && u.Hostname() == host
+}
@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResetHandlerRejectsEmptyHost(t *testing.T) {
+	wm := NewWebsiteMonitor([]SiteConfig{{URL: "https://example.com"}, {URL: "https://example.org"}})
+	for _, site := range wm.Sites() {
+		wm.recordResult(site, PingResult{Status: "success"})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reset", ResetHandler([]*WebsiteMonitor{wm}))
+	mux.HandleFunc("POST /reset/{host...}", ResetHandler([]*WebsiteMonitor{wm}))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reset/", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("POST /reset/ = %d, want 400", rec.Code)
+	}
+	if got := len(wm.GetResults()); got != 2 {
+		t.Fatalf("%d result(s) left after a rejected reset, want 2", got)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reset/example.org", nil))
+	if rec.Code != http.StatusOK || len(wm.GetResults()) != 1 {
+		t.Fatalf("POST /reset/example.org = %d with %d result(s) left, want 200 and 1", rec.Code, len(wm.GetResults()))
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	if rec.Code != http.StatusOK || len(wm.GetResults()) != 0 {
+		t.Fatalf("POST /reset = %d with %d result(s) left, want 200 and 0", rec.Code, len(wm.GetResults()))
+	}
+}
+
+func TestSiteRoutesReachGroupMonitors(t *testing.T) {
+	top := NewWebsiteMonitor([]SiteConfig{{URL: "https://example.com"}})
+	group := NewWebsiteMonitor([]SiteConfig{{URL: "https://edge.example"}})
+	for _, m := range []*WebsiteMonitor{top, group} {
+		m.Clock = NewFakeClock(epoch.Add(time.Hour))
+	}
+	monitors := []*WebsiteMonitor{top, group}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sites/{host}/note", NoteHandler(monitors))
+	mux.HandleFunc("POST /history/import", ImportHistoryHandler(monitors))
+	mux.HandleFunc("POST /reset/{host...}", ResetHandler(monitors))
+
+	serve := func(path, body string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := serve("/sites/edge.example/note", `{"note": "migrating"}`); code != http.StatusOK {
+		t.Errorf("note on a group site = %d, want 200", code)
+	}
+
+	history := fmt.Sprintf(`{"https://example.com": [{"time": %q, "status": "success"}], "https://edge.example": [{"time": %q, "status": "failed"}]}`,
+		epoch.Format(time.RFC3339), epoch.Format(time.RFC3339))
+	if code := serve("/history/import", history); code != http.StatusOK {
+		t.Fatalf("import across monitors = %d, want 200", code)
+	}
+	for _, m := range monitors {
+		if m.Stats().Samples != 1 {
+			t.Errorf("monitor of %s holds %d sample(s), want 1", m.Sites()[0].URL, m.Stats().Samples)
+		}
+	}
+
+	if code := serve("/history/import", `{"https://unknown.example": []}`); code != http.StatusBadRequest {
+		t.Errorf("import for an unknown site = %d, want 400", code)
+	}
+
+	if code := serve("/reset/edge.example", ""); code != http.StatusOK || group.Stats().Samples != 0 || top.Stats().Samples != 1 {
+		t.Errorf("reset of a group site = %d, leaving %d group and %d top-level sample(s), want 200, 0 and 1",
+			code, group.Stats().Samples, top.Stats().Samples)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	list := make([]SiteResult, 5)
+	for i := range list {
+		list[i].Site = fmt.Sprintf("https://site%d.example", i)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"offset=1&limit=2", 2},
+		{"offset=4&limit=10", 1},
+		{"offset=10", 0},
+		{"offset=1&limit=9223372036854775807", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, paginated, err := paginate(list, query)
+			if err != nil || !paginated {
+				t.Fatalf("paginate(%s) = %v, %v", tt.query, paginated, err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("paginate(%s) returned %d result(s), want %d", tt.query, len(got), tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkPingHandler(b *testing.B) {
+	const numSites = 1000
+	sites := make([]SiteConfig, numSites)
+	for i := range sites {
+		sites[i] = SiteConfig{URL: fmt.Sprintf("https://site%d.example", i)}
+	}
+
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		name := "cache off"
+		if ttl > 0 {
+			name = "cache on"
+		}
+		b.Run(name, func(b *testing.B) {
+			wm := NewWebsiteMonitor(sites)
+			wm.PingCacheTTL = ttl
+			for _, site := range sites {
+				wm.recordResult(site, PingResult{Status: "success", Duration: 50 * time.Millisecond, CheckedAt: epoch})
+			}
+			handler := PingHandler(wm)
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rec := httptest.NewRecorder()
+					handler(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+					if rec.Code != http.StatusOK {
+						b.Errorf("status = %d, want 200", rec.Code)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package monitor
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultRedactedHeaders are the response headers hidden from captured
+// headers unless another denylist is configured
+var DefaultRedactedHeaders = []string{"Set-Cookie", "Authorization"}
+
+// captureHeaders flattens response headers for storage, replacing the
+// values of headers named in redact, or DefaultRedactedHeaders when redact
+// is nil, so secrets never reach logs or results
+func captureHeaders(h http.Header, redact []string) map[string]string {
+	if redact == nil {
+		redact = DefaultRedactedHeaders
+	}
+
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		for _, denied := range redact {
+			if strings.EqualFold(name, denied) {
+				value = redacted
+				break
+			}
+		}
+		out[name] = value
+	}
+	return out
+}
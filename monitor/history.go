@@ -0,0 +1,360 @@
+package monitor
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Sample is a single recorded check outcome
+type Sample struct {
+	Time     time.Time     `json:"time"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// history is a fixed-size ring buffer of a site's most recent samples. Its
+// storage grows as samples arrive rather than being allocated up front.
+type history struct {
+	samples []Sample
+	size    int
+	start   int
+	n       int
+
+	// queued is set while the ring is in the monitor's eviction queue
+	queued bool
+}
+
+func newHistory(size int) *history {
+	if size <= 0 {
+		size = 1
+	}
+	return &history{size: size}
+}
+
+// add records a sample, overwriting the oldest once the buffer is full. It
+// reports whether the buffer grew.
+func (h *history) add(s Sample) bool {
+	if h.n == h.size {
+		h.samples[h.start] = s
+		h.start = (h.start + 1) % h.size
+		return false
+	}
+	if h.n == len(h.samples) {
+		// Out of room below the size limit: unwrap if needed and grow
+		if h.start != 0 {
+			h.samples, h.start = h.all(), 0
+		}
+		h.samples = append(h.samples, s)
+	} else {
+		h.samples[(h.start+h.n)%len(h.samples)] = s
+	}
+	h.n++
+	return true
+}
+
+// len returns the number of samples recorded
+func (h *history) len() int {
+	return h.n
+}
+
+// oldest returns the oldest recorded sample
+func (h *history) oldest() (Sample, bool) {
+	if h.n == 0 {
+		return Sample{}, false
+	}
+	return h.samples[h.start], true
+}
+
+// dropOldest discards the oldest recorded sample
+func (h *history) dropOldest() {
+	if h.n == 0 {
+		return
+	}
+	h.samples[h.start] = Sample{}
+	h.start = (h.start + 1) % len(h.samples)
+	h.n--
+}
+
+// all returns the recorded samples in chronological order
+func (h *history) all() []Sample {
+	out := make([]Sample, 0, h.n)
+	for i := 0; i < h.n; i++ {
+		out = append(out, h.samples[(h.start+i)%len(h.samples)])
+	}
+	return out
+}
+
+// merge adds samples in chronological order alongside those already
+// recorded, keeping the most recent ones when over capacity
+func (h *history) merge(samples []Sample) {
+	all := append(h.all(), samples...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Time.Before(all[j].Time) })
+
+	if len(all) > h.size {
+		all = all[len(all)-h.size:]
+	}
+
+	h.samples, h.start, h.n = nil, 0, 0
+	for _, s := range all {
+		h.add(s)
+	}
+}
+
+// siteHistory holds a site's samples in two independently sized rings:
+// every outcome for uptime and burn rates, and successful checks only for
+// latency percentiles
+type siteHistory struct {
+	status  *history
+	latency *history
+}
+
+// newSiteHistory creates a site's history using the monitor's ring sizes
+func (wm *WebsiteMonitor) newSiteHistory() *siteHistory {
+	return &siteHistory{
+		status:  newHistory(wm.StatusHistorySize),
+		latency: newHistory(wm.LatencyHistorySize),
+	}
+}
+
+// add records a sample in the status ring and, if it carries a latency,
+// in the latency ring, returning how many samples the rings grew by
+func (sh *siteHistory) add(s Sample) int {
+	grew := 0
+	if sh.status.add(s) {
+		grew++
+	}
+	if hasLatency(s) && sh.latency.add(s) {
+		grew++
+	}
+	return grew
+}
+
+// len returns the number of samples held across both rings
+func (sh *siteHistory) len() int {
+	return sh.status.len() + sh.latency.len()
+}
+
+// clear empties both rings, returning how many samples they held
+func (sh *siteHistory) clear() int {
+	n := sh.len()
+	*sh.status = history{size: sh.status.size, queued: sh.status.queued}
+	*sh.latency = history{size: sh.latency.size, queued: sh.latency.queued}
+	return n
+}
+
+// merge merges imported samples into both rings
+func (sh *siteHistory) merge(samples []Sample) {
+	sh.status.merge(samples)
+
+	var timed []Sample
+	for _, s := range samples {
+		if hasLatency(s) {
+			timed = append(timed, s)
+		}
+	}
+	sh.latency.merge(timed)
+}
+
+// hasLatency reports whether a sample counts towards latency percentiles
+func hasLatency(s Sample) bool {
+	return healthy(s.Status) && s.Duration > 0
+}
+
+// recent returns up to n of the most recent samples in chronological order
+func (h *history) recent(n int) []Sample {
+	all := h.all()
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return all
+}
+
+// since returns the samples recorded at or after t in chronological order
+func (h *history) since(t time.Time) []Sample {
+	all := h.all()
+	for i, s := range all {
+		if !s.Time.Before(t) {
+			return all[i:]
+		}
+	}
+	return nil
+}
+
+// ImportHistory seeds site histories with externally recorded samples, for
+// example when migrating between instances. Each site's samples must be in
+// strictly increasing time order and within the retention period; they are
+// merged chronologically with anything already recorded. Nothing is
+// imported unless every site validates. It returns the number of samples
+// imported.
+func (wm *WebsiteMonitor) ImportHistory(samples map[string][]Sample) (int, error) {
+	if err := wm.validateImport(samples); err != nil {
+		return 0, err
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	imported := 0
+	for site, list := range samples {
+		h, ok := wm.history[site]
+		if !ok {
+			h = wm.newSiteHistory()
+			wm.history[site] = h
+		}
+		before := h.len()
+		h.merge(list)
+		wm.samples += h.len() - before
+		wm.queue(h)
+		imported += len(list)
+	}
+
+	// Merged samples can be older than a queued ring's key
+	wm.rekeyEvictions()
+	wm.enforceSampleCap()
+
+	return imported, nil
+}
+
+// validateImport checks samples for ImportHistory without importing them
+func (wm *WebsiteMonitor) validateImport(samples map[string][]Sample) error {
+	now := wm.Clock.Now()
+	oldest := now.Add(-wm.HistoryRetention)
+
+	known := make(map[string]bool)
+	for _, site := range wm.Sites() {
+		known[site.URL] = true
+	}
+
+	for site, list := range samples {
+		if !known[site] {
+			return fmt.Errorf("unknown site %s", site)
+		}
+
+		var prev time.Time
+		for i, s := range list {
+			switch {
+			case s.Status != "success" && s.Status != "slow" && s.Status != "failed":
+				return fmt.Errorf("site %s sample %d: invalid status %q: must be success, slow or failed", site, i, s.Status)
+			case s.Time.After(now):
+				return fmt.Errorf("site %s sample %d: time %s is in the future", site, i, s.Time.Format(time.RFC3339))
+			case wm.HistoryRetention > 0 && s.Time.Before(oldest):
+				return fmt.Errorf("site %s sample %d: time %s is outside the %s retention", site, i, s.Time.Format(time.RFC3339), wm.HistoryRetention)
+			case !s.Time.After(prev):
+				return fmt.Errorf("site %s sample %d: times must be strictly increasing", site, i)
+			}
+			prev = s.Time
+		}
+	}
+	return nil
+}
+
+// Stats describes the monitor's stored history and check cycles
+type Stats struct {
+	Sites      int    `json:"sites"`
+	Samples    int    `json:"samples"`
+	MaxSamples int    `json:"max_samples,omitempty"`
+	Evictions  uint64 `json:"evictions"`
+
+	// LastCycleMs is how long the last scheduled check cycle took, and
+	// CycleOverruns how many cycles took longer than the interval
+	LastCycleMs   int64  `json:"last_cycle_ms"`
+	CycleOverruns uint64 `json:"cycle_overruns"`
+}
+
+// Stats returns the number of samples held and evicted, and how check
+// cycles are keeping up
+func (wm *WebsiteMonitor) Stats() Stats {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return Stats{
+		Sites:      len(wm.websites),
+		Samples:    wm.samples,
+		MaxSamples: wm.MaxSamples,
+		Evictions:  wm.evictions.count,
+
+		LastCycleMs:   wm.lastCycle.Milliseconds(),
+		CycleOverruns: wm.overruns,
+	}
+}
+
+// evictionQueue orders history rings by their oldest sample so the
+// globally oldest sample can be found without scanning every site. Keys
+// may lag behind a ring's true oldest sample; they are corrected when the
+// ring reaches the front.
+type evictionQueue []queuedHistory
+
+type queuedHistory struct {
+	oldest time.Time
+	h      *history
+}
+
+func (q evictionQueue) Len() int           { return len(q) }
+func (q evictionQueue) Less(i, j int) bool { return q[i].oldest.Before(q[j].oldest) }
+func (q evictionQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *evictionQueue) Push(x any)        { *q = append(*q, x.(queuedHistory)) }
+func (q *evictionQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// queue adds a site's rings to the eviction queue if they aren't there yet
+func (wm *WebsiteMonitor) queue(sh *siteHistory) {
+	if wm.MaxSamples <= 0 {
+		return
+	}
+	for _, h := range []*history{sh.status, sh.latency} {
+		if s, ok := h.oldest(); ok && !h.queued {
+			h.queued = true
+			heap.Push(&wm.evictions.queue, queuedHistory{oldest: s.Time, h: h})
+		}
+	}
+}
+
+// rekeyEvictions resets every queued ring's key to its oldest sample and
+// restores the queue order. The caller must hold wm.mu.
+func (wm *WebsiteMonitor) rekeyEvictions() {
+	q := wm.evictions.queue
+	for i := range q {
+		if s, ok := q[i].h.oldest(); ok {
+			q[i].oldest = s.Time
+		}
+	}
+	heap.Init(&wm.evictions.queue)
+}
+
+// enforceSampleCap evicts the oldest samples across all sites until the
+// total is within MaxSamples. The caller must hold wm.mu.
+func (wm *WebsiteMonitor) enforceSampleCap() {
+	if wm.MaxSamples <= 0 {
+		return
+	}
+	q := &wm.evictions.queue
+	for wm.samples > wm.MaxSamples && q.Len() > 0 {
+		front := heap.Pop(q).(queuedHistory)
+		s, ok := front.h.oldest()
+		if !ok {
+			// Emptied, or dropped by a reset
+			front.h.queued = false
+			continue
+		}
+		if s.Time.After(front.oldest) {
+			front.oldest = s.Time
+			heap.Push(q, front)
+			continue
+		}
+
+		front.h.dropOldest()
+		wm.samples--
+		wm.evictions.count++
+
+		if s, ok := front.h.oldest(); ok {
+			heap.Push(q, queuedHistory{oldest: s.Time, h: front.h})
+		} else {
+			front.h.queued = false
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package monitor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestImportHistoryEvictsOldestImportedSamples(t *testing.T) {
+	wm := NewWebsiteMonitor([]SiteConfig{{URL: "https://a.example"}, {URL: "https://b.example"}})
+	wm.Clock = NewFakeClock(epoch.Add(time.Hour))
+	wm.StatusHistorySize = 10
+	wm.LatencyHistorySize = 10
+	wm.MaxSamples = 4
+
+	for i, url := range []string{"https://a.example", "https://a.example", "https://b.example", "https://b.example"} {
+		wm.recordResult(SiteConfig{URL: url}, PingResult{Status: "success", CheckedAt: epoch.Add(time.Duration(10+i) * time.Minute)})
+	}
+
+	// b's imported samples are now the oldest of all, so they go first
+	imported := []Sample{{Time: epoch.Add(time.Minute), Status: "failed"}, {Time: epoch.Add(2 * time.Minute), Status: "failed"}}
+	if _, err := wm.ImportHistory(map[string][]Sample{"https://b.example": imported}); err != nil {
+		t.Fatal(err)
+	}
+
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	if got := len(wm.history["https://a.example"].status.all()); got != 2 {
+		t.Errorf("a kept %d sample(s), want 2", got)
+	}
+	for _, s := range wm.history["https://b.example"].status.all() {
+		if s.Status == "failed" {
+			t.Errorf("imported sample at %s survived eviction", s.Time)
+		}
+	}
+}
+
+func BenchmarkRecordResult(b *testing.B) {
+	const numSites = 5000
+	sites := make([]SiteConfig, numSites)
+	for i := range sites {
+		sites[i] = SiteConfig{URL: fmt.Sprintf("https://site%d.example", i)}
+	}
+	wm := NewWebsiteMonitor(sites)
+	wm.MaxSamples = numSites * 50
+
+	b.ReportAllocs()
+	checkedAt := epoch
+	for i := 0; b.Loop(); i++ {
+		checkedAt = checkedAt.Add(time.Millisecond)
+		wm.recordResult(sites[i%numSites], PingResult{Status: "success", Duration: 50 * time.Millisecond, CheckedAt: checkedAt})
+	}
+}
+
+func TestImportHistoryRejectsUnknownStatus(t *testing.T) {
+	wm := NewWebsiteMonitor([]SiteConfig{{URL: "https://a.example"}})
+	wm.Clock = NewFakeClock(epoch.Add(time.Hour))
+
+	for _, status := range []string{"", "up", "ok", "maintenance"} {
+		samples := map[string][]Sample{"https://a.example": {{Time: epoch, Status: status}}}
+		if _, err := wm.ImportHistory(samples); err == nil {
+			t.Errorf("status %q accepted", status)
+		}
+	}
+	if got := wm.Stats().Samples; got != 0 {
+		t.Errorf("%d sample(s) imported, want 0", got)
+	}
+}
@@ -0,0 +1,132 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+)
+
+// http10Transport sends requests as HTTP/1.0 over a fresh connection each
+// time, for legacy servers that can't handle HTTP/1.1. net/http always
+// writes HTTP/1.1 request lines, so requests are written by hand.
+type http10Transport struct {
+	dialer    *net.Dialer
+	tlsConfig *tls.Config
+}
+
+// RoundTrip sends req with Connection: close and reads the response
+func (t *http10Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := t.dial(req.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: conn})
+	}
+
+	// Unblock reads and writes if the request is cancelled
+	stop := context.AfterFunc(req.Context(), func() { conn.Close() })
+
+	if err := writeHTTP10Request(conn, req); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	if _, err := br.Peek(1); err == nil && trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		resp.TLS = &state
+	}
+	resp.Body = &connBody{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, nil
+}
+
+// dial connects to the request's host, over TLS for https
+func (t *http10Transport) dial(ctx context.Context, req *http.Request) (net.Conn, error) {
+	host := req.URL.Hostname()
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	conn, err := t.dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil || req.URL.Scheme != "https" {
+		return conn, err
+	}
+
+	cfg := &tls.Config{}
+	if t.tlsConfig != nil {
+		cfg = t.tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// writeHTTP10Request writes req as an HTTP/1.0 request
+func writeHTTP10Request(w io.Writer, req *http.Request) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s HTTP/1.0\r\n", req.Method, req.URL.RequestURI())
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(bw, "Host: %s\r\n", host)
+
+	header := req.Header.Clone()
+	header.Set("Connection", "close")
+	if header.Get("User-Agent") == "" {
+		header.Set("User-Agent", "Go-http-client/1.0")
+	}
+	if err := header.Write(bw); err != nil {
+		return err
+	}
+	bw.WriteString("\r\n")
+
+	if req.Body != nil {
+		if _, err := io.Copy(bw, req.Body); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// connBody closes the connection along with the response body
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *connBody) Close() error {
+	b.stop()
+	b.ReadCloser.Close()
+	return b.conn.Close()
+}
@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP3Checker checks that a site responds to a GET over HTTP/3 (QUIC)
+type HTTP3Checker struct {
+	TLSConfig *tls.Config
+}
+
+// Check performs the request over a fresh QUIC connection so the handshake
+// is measured on every check
+func (c *HTTP3Checker) Check(ctx context.Context, site SiteConfig) PingResult {
+	target, err := normalizeURL(site)
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Invalid URL: %v", err))
+	}
+	if u, _ := url.Parse(target); u.Scheme != "https" {
+		return deterministicFailure("HTTP/3 checks require an https URL")
+	}
+
+	var handshake atomic.Int64
+	transport := &http3.Transport{
+		TLSClientConfig: c.TLSConfig,
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			start := time.Now()
+			conn, err := quic.DialAddr(ctx, addr, tlsCfg, cfg)
+			handshake.Store(int64(time.Since(start)))
+			return conn, err
+		},
+	}
+	defer transport.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Failed to create request: %v", err))
+	}
+	setHeaders(req, site.Headers)
+
+	client := &http.Client{Transport: transport}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	duration := time.Since(start)
+
+	if err != nil {
+		result := retryableFailure(fmt.Sprintf("No HTTP/3 response (the endpoint may not support QUIC): %v", err))
+		result.Reason = "http3 unavailable"
+		return result
+	}
+	defer resp.Body.Close()
+
+	result := successResult(duration)
+	result.StatusCode = resp.StatusCode
+	result.Protocol = resp.Proto
+	if d := time.Duration(handshake.Load()); d > 0 {
+		result.HandshakeTime = formatDuration(d)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		result.Status = "failed"
+		result.Loss = "100%"
+		result.Error = fmt.Sprintf("Unexpected status: %s", resp.Status)
+		result.FailureKind = FailureDeterministic
+		if resp.StatusCode >= http.StatusInternalServerError {
+			result.FailureKind = FailureRetryable
+		}
+	}
+
+	return result
+}
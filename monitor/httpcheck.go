@@ -0,0 +1,911 @@
+package monitor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// maxBodyBytes caps how much of a response body is read for assertions
+const maxBodyBytes = 1 << 20
+
+// defaultMaxRedirects is how many redirects are followed when a site
+// doesn't set MaxRedirects
+const defaultMaxRedirects = 5
+
+// errTooManyRedirects stops a redirect chain that exceeded its limit
+var errTooManyRedirects = errors.New("too many redirects")
+
+// HTTPChecker checks websites with an HTTP GET request
+type HTTPChecker struct {
+	Client *http.Client
+
+	// Retries is the number of additional attempts made after a retryable
+	// failure, waiting RetryDelay between attempts
+	Retries    int
+	RetryDelay time.Duration
+
+	// MaxClockSkew is how far a server's Date header may be from the
+	// monitor's clock before the result is flagged; 0 disables flagging
+	MaxClockSkew time.Duration
+
+	// RedactHeaders names the response headers whose values are hidden
+	// when headers are captured; nil uses DefaultRedactedHeaders
+	RedactHeaders []string
+
+	// FailureBody configures capturing of response bodies for failed checks
+	FailureBody FailureBodyConfig
+
+	// ProxyAuth are the credentials sent to forward proxies whose URL
+	// doesn't carry its own
+	ProxyAuth *url.Userinfo
+
+	mu         sync.Mutex
+	clients    map[string]*http.Client
+	validators map[string]validators
+	hashes     map[string][sha256.Size]byte
+	snapshots  map[string]snapshot
+	goldens    map[string]snapshot
+	servers    map[string]string
+}
+
+// validators are the cache validators of a target's last full response
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// Check performs an HTTP request to check website health, retrying
+// transport errors and server errors but not deterministic failures
+func (c *HTTPChecker) Check(ctx context.Context, site SiteConfig) PingResult {
+	target, err := normalizeURL(site)
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Invalid URL: %v", err))
+	}
+
+	// Every attempt shares the check's trace so retries correlate
+	traceID := newTraceID()
+	for attempt := 0; ; attempt++ {
+		result := c.attempt(ctx, site, target, traceID)
+		result.RequestID = traceID
+		if site.ServerName != "" || site.HostHeader != "" {
+			if u, err := url.Parse(target); err == nil {
+				result.DialTarget = u.Host
+			}
+			result.PresentedHost = presentedHost(site)
+		}
+		if result.Status == "success" || result.FailureKind != FailureRetryable || attempt >= c.Retries {
+			return result
+		}
+
+		log.Printf("Retrying %s after attempt %d (request %s): %s", target, attempt+1, traceID, result.Error)
+		wait := make(chan struct{})
+		timer := clockFrom(ctx).AfterFunc(c.RetryDelay, func() { close(wait) })
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			timer.Stop()
+			return result
+		}
+	}
+}
+
+// attempt performs a single request against target, bounded by the
+// site's request timeout
+func (c *HTTPChecker) attempt(ctx context.Context, site SiteConfig, target, traceID string) PingResult {
+	if site.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(site.RequestTimeout))
+		defer cancel()
+	}
+
+	reqURL, err := withQuery(target, site.Query, time.Now())
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Invalid query: %v", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Failed to create request: %v", err))
+	}
+	if site.ExpectCompression {
+		// Asking explicitly stops the transport decompressing for us
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	setHeaders(req, site.Headers)
+	if site.HostHeader != "" {
+		req.Host = site.HostHeader
+	}
+	setTraceHeaders(req, traceID)
+	if site.readsBody() && req.Header.Get("Accept-Encoding") == "" {
+		// Decompress bodies ourselves so malformed gzip is reported as such
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if site.Conditional {
+		c.addValidators(req, target)
+	}
+
+	maxRedirects := site.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	client := *c.clientFor(site)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return errTooManyRedirects
+		}
+		return nil
+	}
+
+	trace := &requestTrace{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	received := time.Now()
+	duration := received.Sub(start)
+
+	if errors.Is(err, errTooManyRedirects) {
+		result := deterministicFailure(fmt.Sprintf("Too many redirects (limit %d)", maxRedirects))
+		result.Reason = "too many redirects"
+		return result
+	}
+	if err != nil && site.MinTLSVersion != "" && strings.Contains(err.Error(), "protocol version") {
+		result := deterministicFailure(fmt.Sprintf("TLS handshake failed, TLS %s or later required: %v", site.MinTLSVersion, err))
+		result.Reason = "tls version too old"
+		return result
+	}
+	if err != nil && strings.Contains(err.Error(), "Proxy Authentication Required") {
+		return proxyAuthFailure()
+	}
+	if err != nil {
+		result := retryableFailure(fmt.Sprintf("Request failed: %v", err))
+		result.Reason = timeoutReason(ctx, err)
+		return result
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return proxyAuthFailure()
+	}
+
+	result := successResult(duration)
+	result.StatusCode = resp.StatusCode
+	result.RedirectChain = redirectChain(resp)
+	result.SchemeChange = schemeChange(result.RedirectChain)
+	trace.apply(&result, start)
+	if site.HTTP10 {
+		result.Protocol = resp.Proto
+	}
+	if site.CaptureHeaders {
+		result.Headers = captureHeaders(resp.Header, c.RedactHeaders)
+	}
+	c.measureClockSkew(&result, target, resp.Header.Get("Date"), received)
+	if server := resp.Header.Get("Server"); server != "" {
+		result.ServerHeader = server
+		result.ServerChanged = c.serverChanged(target, server)
+	}
+	if resp.TLS != nil {
+		result.TLSVersion = tls.VersionName(resp.TLS.Version)
+	}
+
+	if min, err := parseTLSVersion(site.MinTLSVersion); err == nil && min != 0 {
+		if resp.TLS == nil {
+			result.Reason = "tls version too old"
+			return c.fail(result, target, FailureDeterministic,
+				fmt.Sprintf("Connection not encrypted, TLS %s or later required", site.MinTLSVersion), nil)
+		}
+		if resp.TLS.Version < min {
+			result.Reason = "tls version too old"
+			return c.fail(result, target, FailureDeterministic,
+				fmt.Sprintf("Negotiated %s, TLS %s or later required", result.TLSVersion, site.MinTLSVersion), nil)
+		}
+	}
+
+	mapped, isMapped := site.StatusMap[resp.StatusCode]
+	if isMapped && mapped != "success" && mapped != "failed" {
+		result.Status = mapped
+		return result
+	}
+
+	var body []byte
+	rules := &ruleSet{any: site.AssertionMode == AssertAny}
+	done := func() PingResult {
+		result.Assertions = rules.results
+		failure := rules.failure()
+		if failure == nil {
+			return result
+		}
+		if failure.reason != "" {
+			result.Reason = failure.reason
+		}
+		var failureBody io.Reader
+		if failure.withBody {
+			failureBody = responseBody(resp)
+			if body != nil {
+				failureBody = bytes.NewReader(body)
+			}
+		}
+		return c.fail(result, target, failure.kind, failure.msg, failureBody)
+	}
+
+	statusFailed := mapped == "failed" || (!isMapped && resp.StatusCode >= http.StatusBadRequest)
+	var statusFailure *ruleFailure
+	if statusFailed {
+		kind := FailureDeterministic
+		if site.retriesStatus(resp.StatusCode) {
+			kind = FailureRetryable
+		}
+		statusFailure = &ruleFailure{kind: kind, msg: fmt.Sprintf("Unexpected status: %s", resp.Status), withBody: true}
+	}
+	if rules.eval("status", statusFailure) {
+		return done()
+	}
+
+	if site.MaxTTFBMs > 0 {
+		var failure *ruleFailure
+		if result.TTFBMs > site.MaxTTFBMs {
+			failure = &ruleFailure{
+				kind:   FailureRetryable,
+				msg:    fmt.Sprintf("Time to first byte %.2f ms exceeds %.2f ms", result.TTFBMs, site.MaxTTFBMs),
+				reason: "slow first byte",
+			}
+		}
+		if rules.eval("ttfb", failure) {
+			return done()
+		}
+	}
+
+	if site.ExpectFinalURL != "" {
+		var failure *ruleFailure
+		if final := resp.Request.URL.String(); !sameURL(final, site.ExpectFinalURL) {
+			failure = &ruleFailure{kind: FailureDeterministic, msg: fmt.Sprintf("Redirected to %s, expected %s", final, site.ExpectFinalURL)}
+		}
+		if rules.eval("final_url", failure) {
+			return done()
+		}
+	}
+
+	if site.ExpectHTTPSUpgrade {
+		var failure *ruleFailure
+		if resp.Request.URL.Scheme != "https" {
+			failure = &ruleFailure{
+				kind:   FailureDeterministic,
+				msg:    fmt.Sprintf("Not redirected to HTTPS, ended at %s", resp.Request.URL),
+				reason: "no https upgrade",
+			}
+		}
+		if rules.eval("https_upgrade", failure) {
+			return done()
+		}
+	}
+
+	// A 304 confirms the content we validated before is unchanged
+	if resp.StatusCode == http.StatusNotModified {
+		return done()
+	}
+	if site.Conditional && !statusFailed {
+		c.storeValidators(target, resp.Header)
+	}
+
+	if !site.readsBody() {
+		return done()
+	}
+
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		result.Reason = timeoutReason(ctx, err)
+		return c.fail(result, target, FailureRetryable, fmt.Sprintf("Failed to read body: %v", err), nil)
+	}
+	// Once the body is read the total time includes the transfer
+	result.Duration = time.Since(start)
+	result.AvgTime = formatDuration(result.Duration)
+
+	if site.ExpectCompression {
+		result.ContentEncoding = resp.Header.Get("Content-Encoding")
+		var failure *ruleFailure
+		if !strings.EqualFold(result.ContentEncoding, "gzip") {
+			failure = &ruleFailure{
+				kind:   FailureDeterministic,
+				msg:    fmt.Sprintf("Expected gzip compression, got Content-Encoding %q", result.ContentEncoding),
+				reason: "compression not applied",
+			}
+		} else if ratio, err := compressionRatio(body, len(body) == maxBodyBytes); err != nil {
+			failure = invalidGzip(err)
+		} else {
+			result.CompressionRatio = ratio
+		}
+		if rules.eval("compression", failure) {
+			return done()
+		}
+	}
+
+	if !resp.Uncompressed && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		if decoded, err := gunzip(body, len(body) == maxBodyBytes); err == nil {
+			body = decoded
+		} else if !site.ExpectCompression {
+			// With expect_compression the compression assertion has
+			// already failed for the same reason
+			if rules.eval("gzip", invalidGzip(err)) {
+				return done()
+			}
+		}
+	}
+
+	if site.DetectContentChange {
+		result.ContentChanged = c.contentChanged(target, body)
+	}
+
+	result.BodyBytes = len(body)
+	if site.MinBytes > 0 || site.MaxBytes > 0 {
+		var failure *ruleFailure
+		if len(body) < site.MinBytes {
+			failure = &ruleFailure{
+				kind:   FailureDeterministic,
+				msg:    fmt.Sprintf("Body is %d bytes, expected at least %d", len(body), site.MinBytes),
+				reason: "unexpected body size",
+			}
+		} else if site.MaxBytes > 0 && len(body) > site.MaxBytes {
+			failure = &ruleFailure{
+				kind:     FailureDeterministic,
+				msg:      fmt.Sprintf("Body is %d bytes, expected at most %d", len(body), site.MaxBytes),
+				reason:   "unexpected body size",
+				withBody: true,
+			}
+		}
+		if rules.eval("body_size", failure) {
+			return done()
+		}
+	}
+
+	if site.CompareToGolden {
+		var failure *ruleFailure
+		if diffs := c.compareGolden(target, newSnapshot(resp.StatusCode, body), site.GoldenIgnore); len(diffs) > 0 {
+			result.GoldenDiff = diffs
+			failure = &ruleFailure{
+				kind:   FailureDeterministic,
+				msg:    fmt.Sprintf("Response differs from golden: %s", strings.Join(diffs, "; ")),
+				reason: "golden mismatch",
+			}
+		}
+		if rules.eval("golden", failure) {
+			return done()
+		}
+	}
+
+	if site.ExpectBody != "" {
+		var failure *ruleFailure
+		if !bytes.Contains(body, []byte(site.ExpectBody)) {
+			failure = &ruleFailure{kind: FailureDeterministic, msg: fmt.Sprintf("Body does not contain %q", site.ExpectBody), withBody: true}
+		}
+		if rules.eval("body", failure) {
+			return done()
+		}
+	}
+	if site.ExpectBodyRegex != "" {
+		var failure *ruleFailure
+		if re, err := site.bodyPattern(); err != nil {
+			failure = &ruleFailure{kind: FailureDeterministic, msg: fmt.Sprintf("Invalid body regex: %v", err)}
+		} else if !re.Match(body) {
+			failure = &ruleFailure{kind: FailureDeterministic, msg: fmt.Sprintf("Body does not match /%s/", site.ExpectBodyRegex), withBody: true}
+		}
+		if rules.eval("body_regex", failure) {
+			return done()
+		}
+	}
+	if len(site.ExpectBodyAbsent) > 0 {
+		var failure *ruleFailure
+		for _, s := range site.ExpectBodyAbsent {
+			if bytes.Contains(body, []byte(s)) {
+				failure = &ruleFailure{
+					kind:     FailureDeterministic,
+					msg:      fmt.Sprintf("Body contains %q", s),
+					reason:   "unwanted content",
+					withBody: true,
+				}
+				break
+			}
+		}
+		if rules.eval("body_absent", failure) {
+			return done()
+		}
+	}
+
+	return done()
+}
+
+// responseBody returns resp's unread body, decompressing it when we asked
+// for gzip ourselves and the transport left it compressed
+func responseBody(resp *http.Response) io.Reader {
+	if resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp.Body
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return strings.NewReader(fmt.Sprintf("<invalid gzip body: %v>", err))
+	}
+	return zr
+}
+
+// invalidGzip fails a check whose gzip body can't be decoded
+func invalidGzip(err error) *ruleFailure {
+	return &ruleFailure{
+		kind:   FailureDeterministic,
+		msg:    fmt.Sprintf("Invalid gzip body: %v", err),
+		reason: "invalid gzip",
+	}
+}
+
+// gunzip decompresses body, keeping at most maxBodyBytes of output. A
+// truncated body decompresses as far as it goes.
+func gunzip(body []byte, truncated bool) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(zr, maxBodyBytes))
+	if err != nil && !(truncated && errors.Is(err, io.ErrUnexpectedEOF)) {
+		return nil, err
+	}
+	return out, nil
+}
+
+// compressionRatio decompresses a gzip body and returns how many times
+// larger the content is than its compressed form. A body cut short by the
+// read cap is measured as far as it goes.
+func compressionRatio(body []byte, truncated bool) (float64, error) {
+	if len(body) == 0 {
+		return 0, fmt.Errorf("empty body")
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	n, err := io.Copy(io.Discard, zr)
+	if err != nil && !(truncated && errors.Is(err, io.ErrUnexpectedEOF)) {
+		return 0, err
+	}
+
+	return math.Round(float64(n)/float64(len(body))*100) / 100, nil
+}
+
+// redirectChain lists the URLs visited to reach resp, starting with the
+// original request, or nil if no redirects were followed
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for req := resp.Request; req != nil; {
+		chain = append([]string{req.URL.String()}, chain...)
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	if len(chain) < 2 {
+		return nil
+	}
+	return chain
+}
+
+// schemeChange describes how a redirect chain moved between http and
+// https, or returns "" if it stayed on one scheme
+func schemeChange(chain []string) string {
+	if len(chain) < 2 {
+		return ""
+	}
+	schemes := make([]string, 0, len(chain))
+	for _, raw := range chain {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return ""
+		}
+		schemes = append(schemes, u.Scheme)
+	}
+
+	for i := 1; i < len(schemes); i++ {
+		if schemes[i-1] == "https" && schemes[i] == "http" {
+			return "downgraded to http"
+		}
+	}
+	if schemes[0] == "http" && schemes[len(schemes)-1] == "https" {
+		return "upgraded to https"
+	}
+	return ""
+}
+
+// sameURL compares two URLs, treating an empty path as "/"
+func sameURL(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	for _, u := range []*url.URL{ua, ub} {
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	}
+	return ua.String() == ub.String()
+}
+
+// fail marks a result as failed, keeping its timing and status code, and
+// attaches the response body when failure bodies are captured
+func (c *HTTPChecker) fail(result PingResult, target, kind, msg string, body io.Reader) PingResult {
+	result.Status = "failed"
+	result.Loss = "100%"
+	result.Error = msg
+	result.FailureKind = kind
+	if body != nil {
+		c.attachFailureBody(&result, target, body)
+	}
+	return result
+}
+
+// addValidators makes req conditional on the validators seen last time
+func (c *HTTPChecker) addValidators(req *http.Request, target string) {
+	c.mu.Lock()
+	v, ok := c.validators[target]
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+}
+
+// Reset forgets what earlier checks of the targets matching host, or of
+// every target when host is empty, have seen: validators, content hashes,
+// Server headers and snapshots, including golden ones
+func (c *HTTPChecker) Reset(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matches := func(target string) bool { return host == "" || matchesHost(target, host) }
+	maps.DeleteFunc(c.validators, func(target string, _ validators) bool { return matches(target) })
+	maps.DeleteFunc(c.hashes, func(target string, _ [sha256.Size]byte) bool { return matches(target) })
+	maps.DeleteFunc(c.servers, func(target string, _ string) bool { return matches(target) })
+	maps.DeleteFunc(c.snapshots, func(target string, _ snapshot) bool { return matches(target) })
+	maps.DeleteFunc(c.goldens, func(target string, _ snapshot) bool { return matches(target) })
+}
+
+// storeValidators remembers the ETag and Last-Modified of a full response
+func (c *HTTPChecker) storeValidators(target string, h http.Header) {
+	v := validators{etag: h.Get("ETag"), lastModified: h.Get("Last-Modified")}
+	if v.etag == "" && v.lastModified == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.validators == nil {
+		c.validators = make(map[string]validators)
+	}
+	c.validators[target] = v
+}
+
+// contentChanged records the hash of target's body, reporting whether it
+// differs from the previous one. The first body seen is never a change.
+func (c *HTTPChecker) contentChanged(target string, body []byte) bool {
+	sum := sha256.Sum256(body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hashes == nil {
+		c.hashes = make(map[string][sha256.Size]byte)
+	}
+	prev, ok := c.hashes[target]
+	c.hashes[target] = sum
+	return ok && prev != sum
+}
+
+// serverChanged records the Server header target sent and reports whether
+// it differs from the last one seen. Responses without the header are
+// ignored rather than counted as a change.
+func (c *HTTPChecker) serverChanged(target, server string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.servers == nil {
+		c.servers = make(map[string]string)
+	}
+	prev, ok := c.servers[target]
+	c.servers[target] = server
+	if ok && prev != server {
+		log.Printf("Server header of %s changed from %q to %q", target, prev, server)
+		return true
+	}
+	return false
+}
+
+// proxyAuthFailure reports a forward proxy rejecting our credentials,
+// which says nothing about the target itself
+func proxyAuthFailure() PingResult {
+	result := deterministicFailure("Proxy authentication failed: 407 Proxy Authentication Required")
+	result.StatusCode = http.StatusProxyAuthRequired
+	result.Reason = "proxy auth failed"
+	return result
+}
+
+// clientFor returns the client used to check site. Sites needing their own
+// connection settings get a dedicated client, built once and reused by
+// every site with the same settings.
+func (c *HTTPChecker) clientFor(site SiteConfig) *http.Client {
+	base := c.Client
+	if base == nil {
+		base = http.DefaultClient
+	}
+
+	key := transportKey(site)
+	if key == "" && c.ProxyAuth == nil {
+		return base
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[key]; ok {
+		return client
+	}
+
+	transport, ok := base.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.DisableKeepAlives = site.DisableKeepAlive
+	if site.SourceIP != "" || site.ConnectTimeout > 0 {
+		transport.DialContext = siteDialer(site).DialContext
+	}
+	if site.Proxy != "" || c.ProxyAuth != nil {
+		transport.Proxy = c.proxyFor(site)
+	}
+	if min, err := parseTLSVersion(site.MinTLSVersion); err == nil && min != 0 {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.MinVersion = min
+	}
+	if site.ServerName != "" {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.ServerName = site.ServerName
+	}
+
+	client := *base
+	client.Transport = transport
+	if site.HTTP10 {
+		client.Transport = &http10Transport{dialer: siteDialer(site), tlsConfig: transport.TLSClientConfig}
+	}
+
+	if c.clients == nil {
+		c.clients = make(map[string]*http.Client)
+	}
+	c.clients[key] = &client
+
+	return &client
+}
+
+// proxyFor returns the proxy selector for site: its configured proxy or
+// the environment's, with ProxyAuth added when the proxy URL has no
+// credentials of its own. The transport sends them as Proxy-Authorization,
+// including on CONNECT for HTTPS targets.
+func (c *HTTPChecker) proxyFor(site SiteConfig) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		var proxy *url.URL
+		var err error
+		if site.Proxy != "" {
+			proxy, err = url.Parse(site.Proxy)
+		} else {
+			proxy, err = http.ProxyFromEnvironment(req)
+		}
+		if err != nil || proxy == nil {
+			return proxy, err
+		}
+
+		if c.ProxyAuth != nil && proxy.User == nil {
+			withAuth := *proxy
+			withAuth.User = c.ProxyAuth
+			proxy = &withAuth
+		}
+		return proxy, nil
+	}
+}
+
+// transportKey identifies the non-default connection settings a site
+// needs, or "" when the shared client will do
+func transportKey(site SiteConfig) string {
+	var opts []string
+	if site.DisableKeepAlive {
+		opts = append(opts, "no-keep-alive")
+	}
+	if site.HTTP10 {
+		opts = append(opts, "http10")
+	}
+	if site.SourceIP != "" {
+		opts = append(opts, "source="+site.SourceIP)
+	}
+	if site.MinTLSVersion != "" {
+		opts = append(opts, "min-tls="+site.MinTLSVersion)
+	}
+	if site.ConnectTimeout > 0 {
+		opts = append(opts, "connect-timeout="+site.ConnectTimeout.String())
+	}
+	if site.Proxy != "" {
+		opts = append(opts, "proxy="+site.Proxy)
+	}
+	if site.ServerName != "" {
+		opts = append(opts, "sni="+site.ServerName)
+	}
+	return strings.Join(opts, ",")
+}
+
+// measureClockSkew compares a response's Date header with when it was
+// received. Date has one-second resolution, so the local time is truncated
+// to match. Responses without a valid Date are left alone.
+func (c *HTTPChecker) measureClockSkew(result *PingResult, target, date string, received time.Time) {
+	if date == "" {
+		return
+	}
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+
+	skew := serverTime.Sub(received.Truncate(time.Second))
+	result.ClockSkewMs = int(skew.Milliseconds())
+	if c.MaxClockSkew > 0 && skew.Abs() > c.MaxClockSkew {
+		result.ClockSkewed = true
+		log.Printf("Clock of %s is off by %s (limit %s)", target, skew, c.MaxClockSkew)
+	}
+}
+
+// presentedHost describes the names a site presents in place of its URL's
+// host: the TLS server name and the Host header, when they differ
+func presentedHost(site SiteConfig) string {
+	switch {
+	case site.HostHeader == "" || site.HostHeader == site.ServerName:
+		return site.ServerName
+	case site.ServerName == "":
+		return site.HostHeader
+	}
+	return fmt.Sprintf("sni=%s host=%s", site.ServerName, site.HostHeader)
+}
+
+// parseTLSVersion converts a version such as "1.2" to its crypto/tls
+// constant. An empty version parses as 0.
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid min_tls_version %q: must be 1.0, 1.1, 1.2 or 1.3", v)
+}
+
+// queryData is the data available to query parameter templates
+type queryData struct {
+	// Timestamp is the current Unix time, useful as a cache-buster
+	Timestamp int64
+}
+
+// parseQueryTemplate parses a query parameter value as a template
+func parseQueryTemplate(name, value string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(value)
+}
+
+// withQuery adds query parameters to target, expanding any templates in
+// their values. Parameters already in target are replaced.
+func withQuery(target string, query map[string]string, now time.Time) (string, error) {
+	if len(query) == 0 {
+		return target, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	values := u.Query()
+	data := queryData{Timestamp: now.Unix()}
+	for name, value := range query {
+		tmpl, err := parseQueryTemplate(name, value)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", err
+		}
+		values.Set(name, b.String())
+	}
+	u.RawQuery = values.Encode()
+
+	return u.String(), nil
+}
+
+// normalizeURL turns a site's URL into an absolute http(s) URL. Targets
+// without a scheme default to https, or http when the port is 80, and a
+// configured Port replaces any port given in the URL.
+func normalizeURL(site SiteConfig) (string, error) {
+	raw := site.URL
+	if !strings.Contains(raw, "://") {
+		scheme := "https"
+		port := site.Port
+		if port == 0 {
+			if u, err := url.Parse("//" + raw); err == nil && u.Port() != "" {
+				port, _ = strconv.Atoi(u.Port())
+			}
+		}
+		if port == 80 {
+			scheme = "http"
+		}
+		raw = scheme + "://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("missing host")
+	}
+
+	if site.Port != 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(site.Port))
+	}
+
+	return u.String(), nil
+}
+
+// attachFailureBody logs the captured body of a failed check and, when
+// configured, appends it to the result's error
+func (c *HTTPChecker) attachFailureBody(result *PingResult, target string, body io.Reader) {
+	if !c.FailureBody.Enabled {
+		return
+	}
+
+	text := c.FailureBody.capture(body)
+	if result.Headers != nil {
+		log.Printf("Check for %s failed (%s), headers: %v, body: %s", target, result.Error, result.Headers, text)
+	} else {
+		log.Printf("Check for %s failed (%s), body: %s", target, result.Error, text)
+	}
+	if c.FailureBody.InResult {
+		result.Error += ": " + text
+	}
+}
@@ -0,0 +1,232 @@
+package monitor
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name string
+		site SiteConfig
+		want string
+	}{
+		{"bare host", SiteConfig{URL: "example.com"}, "https://example.com"},
+		{"host and port", SiteConfig{URL: "example.com:8080"}, "https://example.com:8080"},
+		{"host and port 80", SiteConfig{URL: "example.com:80"}, "http://example.com:80"},
+		{"http with port", SiteConfig{URL: "http://example.com:8080"}, "http://example.com:8080"},
+		{"https", SiteConfig{URL: "https://example.com"}, "https://example.com"},
+		{"configured port", SiteConfig{URL: "https://example.com:8443/health", Port: 9443}, "https://example.com:9443/health"},
+		{"configured port 80 on bare host", SiteConfig{URL: "example.com", Port: 80}, "http://example.com:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeURL(tt.site)
+			if err != nil {
+				t.Fatalf("normalizeURL(%q) failed: %v", tt.site.URL, err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeURL(%q) = %q, want %q", tt.site.URL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeURLRejectsInvalid(t *testing.T) {
+	for _, raw := range []string{"ftp://example.com", "http://"} {
+		if got, err := normalizeURL(SiteConfig{URL: raw}); err == nil {
+			t.Errorf("normalizeURL(%q) = %q, want an error", raw, got)
+		}
+	}
+}
+
+// checkHTTP runs c against site with a short timeout
+func checkHTTP(t *testing.T, c *HTTPChecker, site SiteConfig) PingResult {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.Check(ctx, site)
+}
+
+func TestHTTPCheckerSuccess(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("all good"))
+	}))
+	defer srv.Close()
+
+	result := checkHTTP(t, &HTTPChecker{}, SiteConfig{URL: srv.URL, ExpectBody: "good"})
+	if result.Status != "success" {
+		t.Fatalf("status = %q (%s), want success", result.Status, result.Error)
+	}
+	if result.StatusCode != http.StatusOK {
+		t.Errorf("status code = %d, want 200", result.StatusCode)
+	}
+}
+
+func TestHTTPCheckerFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		site     SiteConfig
+		wantKind string
+	}{
+		{"client error", http.StatusNotFound, "", SiteConfig{}, FailureDeterministic},
+		{"server error", http.StatusServiceUnavailable, "", SiteConfig{}, FailureRetryable},
+		{"missing body text", http.StatusOK, "oops", SiteConfig{ExpectBody: "good"}, FailureDeterministic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			tt.site.URL = srv.URL
+			result := checkHTTP(t, &HTTPChecker{}, tt.site)
+			if result.Status != "failed" {
+				t.Fatalf("status = %q, want failed", result.Status)
+			}
+			if result.FailureKind != tt.wantKind {
+				t.Errorf("failure kind = %q, want %q", result.FailureKind, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckerRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		retryOn      []int
+		wantRequests int32
+	}{
+		{"5xx retried by default", http.StatusBadGateway, nil, 3},
+		{"4xx not retried by default", http.StatusUnauthorized, nil, 1},
+		{"listed status retried", http.StatusTooManyRequests, []int{429}, 3},
+		{"unlisted 5xx not retried", http.StatusInternalServerError, []int{503}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			c := &HTTPChecker{Retries: 2, RetryDelay: time.Millisecond}
+			result := checkHTTP(t, c, SiteConfig{URL: srv.URL, RetryOnStatus: tt.retryOn})
+			if result.Status != "failed" {
+				t.Fatalf("status = %q, want failed", result.Status)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("server saw %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckerConnectionRefused(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	result := checkHTTP(t, &HTTPChecker{}, SiteConfig{URL: url})
+	if result.Status != "failed" || result.FailureKind != FailureRetryable {
+		t.Errorf("got %q/%q, want a retryable failure", result.Status, result.FailureKind)
+	}
+}
+
+func TestHTTPCheckerCompressionAssertion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("all good"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		mode       string
+		wantStatus string
+	}{
+		{AssertAll, "failed"},
+		{AssertAny, "success"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			site := SiteConfig{URL: srv.URL, ExpectCompression: true, ExpectBody: "good", AssertionMode: tt.mode}
+			result := checkHTTP(t, &HTTPChecker{}, site)
+			if result.Status != tt.wantStatus {
+				t.Fatalf("status = %q (%s), want %q", result.Status, result.Error, tt.wantStatus)
+			}
+
+			var found bool
+			for _, a := range result.Assertions {
+				if a.Name == "compression" {
+					found = true
+					if a.Passed {
+						t.Error("compression assertion passed for an uncompressed body")
+					}
+				}
+			}
+			if !found {
+				t.Errorf("no compression assertion in %+v", result.Assertions)
+			}
+			if tt.wantStatus == "failed" && result.Reason != "compression not applied" {
+				t.Errorf("reason = %q, want compression not applied", result.Reason)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckerFailureBodyIsDecompressed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusInternalServerError)
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte("database unavailable"))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	c := &HTTPChecker{FailureBody: FailureBodyConfig{Enabled: true, InResult: true}}
+	result := checkHTTP(t, c, SiteConfig{URL: srv.URL, ExpectBody: "ok"})
+	if !strings.HasSuffix(result.Error, ": database unavailable") {
+		t.Errorf("error = %q, want the decompressed body", result.Error)
+	}
+}
+
+func TestResetClearsCheckerState(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if n > 1 && r.Header.Get("If-None-Match") != "" {
+			t.Errorf("request %d sent If-None-Match %q after a reset", n, r.Header.Get("If-None-Match"))
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, n))
+		fmt.Fprintf(w, "version %d", n)
+	}))
+	defer srv.Close()
+
+	site := SiteConfig{URL: srv.URL, DetectContentChange: true, Conditional: true}
+	checker := &HTTPChecker{}
+	wm := NewWebsiteMonitor([]SiteConfig{site})
+	wm.RegisterChecker(CheckHTTP, checker)
+
+	checkHTTP(t, checker, site)
+	wm.Reset("")
+	if result := checkHTTP(t, checker, site); result.ContentChanged {
+		t.Error("first check after a reset reported content_changed")
+	}
+}
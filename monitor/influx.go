@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxBatchSize is how many points are written per request by default
+const influxBatchSize = 5000
+
+// Influx exports results as InfluxDB line protocol, one site_check point
+// per site, to a write endpoint such as
+// http://influx:8086/api/v2/write?org=ops&bucket=monitor. Each point is
+// tagged with the site, group and status and carries value (1 when the
+// site is up, 0 when down), latency_ms and, for HTTP checks, status_code.
+type Influx struct {
+	URL    string
+	Client *http.Client
+
+	// Token is sent as "Authorization: Token ..." when set
+	Token string
+
+	// Group tags each point with the monitor group
+	Group string
+
+	// BatchSize caps the points per request; 0 uses 5000
+	BatchSize int
+
+	// Attempts is how many times each batch is tried, with Backoff
+	// doubling between attempts
+	Attempts int
+	Backoff  time.Duration
+}
+
+// Export writes a point for each checked site, in batches
+func (f *Influx) Export(snapshot pingResponse) {
+	var points []string
+	for _, sr := range snapshot.Results {
+		if point, ok := f.point(sr); ok {
+			points = append(points, point)
+		}
+	}
+
+	size := f.BatchSize
+	if size <= 0 {
+		size = influxBatchSize
+	}
+	for len(points) > 0 {
+		n := min(size, len(points))
+		f.write(strings.Join(points[:n], "\n") + "\n")
+		points = points[n:]
+	}
+}
+
+// point formats a result as a line protocol point, skipping sites without
+// an up or down outcome
+func (f *Influx) point(sr SiteResult) (string, bool) {
+	var value int
+	switch sr.Result.Status {
+	case "success", "slow":
+		value = 1
+	case "failed":
+		value = 0
+	default:
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString("site_check,site=")
+	b.WriteString(influxTag(sr.Site))
+	if f.Group != "" {
+		b.WriteString(",group=")
+		b.WriteString(influxTag(f.Group))
+	}
+	if sr.Result.Name != "" {
+		b.WriteString(",name=")
+		b.WriteString(influxTag(sr.Result.Name))
+	}
+	b.WriteString(",status=")
+	b.WriteString(sr.Result.Status)
+
+	fmt.Fprintf(&b, " value=%d", value)
+	if sr.Result.Duration > 0 {
+		b.WriteString(",latency_ms=")
+		b.WriteString(strconv.FormatFloat(float64(sr.Result.Duration.Microseconds())/1000, 'f', -1, 64))
+	}
+	if sr.Result.StatusCode > 0 {
+		fmt.Fprintf(&b, ",status_code=%di", sr.Result.StatusCode)
+	}
+	if !sr.Result.CheckedAt.IsZero() {
+		fmt.Fprintf(&b, " %d", sr.Result.CheckedAt.UnixNano())
+	}
+	return b.String(), true
+}
+
+// write sends a batch, retrying failures. Batches that can't be delivered
+// are logged and dropped; the next cycle writes fresh points.
+func (f *Influx) write(body string) {
+	attempts := max(f.Attempts, 1)
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f.send(body)
+		if err == nil {
+			return
+		}
+		if attempt == attempts {
+			break
+		}
+		time.Sleep(jitter(f.Backoff << (attempt - 1)))
+	}
+	log.Printf("Failed to write results to InfluxDB at %s after %d attempt(s): %v", f.URL, attempts, err)
+}
+
+// send makes a single write attempt
+func (f *Influx) send(body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if f.Token != "" {
+		req.Header.Set("Authorization", "Token "+f.Token)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("InfluxDB returned %s", resp.Status)
+	}
+	return nil
+}
+
+// influxTag escapes a tag value for line protocol. Newlines can't be
+// escaped, so they are replaced.
+var influxTag = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", "_").Replace
@@ -0,0 +1,170 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMaintenanceDuration bounds how long a single window may last
+const maxMaintenanceDuration = 7 * 24 * time.Hour
+
+// MaintenanceWindow is a recurring period of planned downtime. Schedule is
+// a five-field cron expression (minute hour day-of-month month day-of-week)
+// in UTC giving when the window opens, and Duration how long it stays open.
+type MaintenanceWindow struct {
+	Schedule string   `json:"schedule"`
+	Duration Duration `json:"duration"`
+
+	cron *cronSchedule
+}
+
+// compile parses the window's schedule
+func (w *MaintenanceWindow) compile() error {
+	if w.Duration <= 0 || time.Duration(w.Duration) > maxMaintenanceDuration {
+		return fmt.Errorf("maintenance duration must be between 0 and %s", maxMaintenanceDuration)
+	}
+	cron, err := parseCron(w.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid maintenance schedule %q: %w", w.Schedule, err)
+	}
+	w.cron = cron
+	return nil
+}
+
+// active reports whether the window is open at t, i.e. whether it opened
+// within the last Duration
+func (w MaintenanceWindow) active(t time.Time) bool {
+	cron := w.cron
+	if cron == nil {
+		var err error
+		if cron, err = parseCron(w.Schedule); err != nil {
+			return false
+		}
+	}
+
+	t = t.UTC()
+	for start := t.Truncate(time.Minute); t.Sub(start) < time.Duration(w.Duration); start = start.Add(-time.Minute) {
+		if cron.matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// inMaintenance reports whether any of the site's windows is open at t
+func (s SiteConfig) inMaintenance(t time.Time) bool {
+	for _, w := range s.Maintenance {
+		if w.active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceResult marks a result taken during planned downtime
+func maintenanceResult(result PingResult) PingResult {
+	result.Status = "maintenance"
+	result.FailureKind = ""
+	return result
+}
+
+// cronSchedule is a parsed cron expression, each field a bitmask of the
+// values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny record unrestricted day fields; when both day
+	// fields are restricted, either may match, as in cron
+	domAny, dowAny bool
+}
+
+// parseCron parses a standard five-field cron expression. Each field
+// accepts *, values, ranges (a-b), steps (*/n, a-b/n) and comma lists.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	// Both 0 and 7 mean Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+
+	return &c, nil
+}
+
+// parseCronField parses one field into a bitmask of values in [min, max]
+func parseCronField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", loStr)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiStr)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << v
+		}
+	}
+	return mask, nil
+}
+
+// matches reports whether the schedule fires at the minute containing t
+func (c *cronSchedule) matches(t time.Time) bool {
+	if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
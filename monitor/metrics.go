@@ -0,0 +1,85 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus collectors exported by the monitor
+type Metrics struct {
+	responseSeconds *prometheus.HistogramVec
+	cycleSeconds    *prometheus.HistogramVec
+	cycleOverruns   *prometheus.CounterVec
+}
+
+// NewMetrics creates the monitor's collectors and registers them with reg
+func NewMetrics(reg prometheus.Registerer, buckets []float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
+	m := &Metrics{
+		responseSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "site_response_seconds",
+			Help:    "Response time of site health checks in seconds.",
+			Buckets: buckets,
+		}, []string{"site"}),
+		cycleSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "check_cycle_seconds",
+			Help:    "Time taken by scheduled check cycles to check every site, in seconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		}, []string{"group"}),
+		cycleOverruns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "check_cycle_overruns_total",
+			Help: "Scheduled check cycles that took longer than the check interval.",
+		}, []string{"group"}),
+	}
+	reg.MustRegister(m.responseSeconds, m.cycleSeconds, m.cycleOverruns)
+
+	return m
+}
+
+// observe records the latency of a completed check
+func (m *Metrics) observe(site string, result PingResult) {
+	if m == nil || result.Duration <= 0 {
+		return
+	}
+	m.responseSeconds.WithLabelValues(site).Observe(result.Duration.Seconds())
+}
+
+// observeCycle records the duration of a group's check cycle and whether
+// it overran the interval
+func (m *Metrics) observeCycle(group string, elapsed time.Duration, overrun bool) {
+	if m == nil {
+		return
+	}
+	m.cycleSeconds.WithLabelValues(group).Observe(elapsed.Seconds())
+	if overrun {
+		m.cycleOverruns.WithLabelValues(group).Inc()
+	}
+}
+
+// ParseBuckets parses a list of histogram bucket upper bounds in seconds,
+// which must be finite and strictly increasing
+func ParseBuckets(values []string) ([]float64, error) {
+	buckets := make([]float64, 0, len(values))
+	for _, v := range values {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", v, err)
+		}
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return nil, fmt.Errorf("invalid bucket %q: must be a finite number", v)
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be in strictly increasing order")
+		}
+		buckets = append(buckets, b)
+	}
+
+	return buckets, nil
+}
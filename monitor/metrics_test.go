@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseBuckets(t *testing.T) {
+	got, err := ParseBuckets([]string{"0.1", "0.5", "1", "2.5"})
+	if err != nil {
+		t.Fatalf("ParseBuckets failed: %v", err)
+	}
+	if want := []float64{0.1, 0.5, 1, 2.5}; !slices.Equal(got, want) {
+		t.Errorf("ParseBuckets = %v, want %v", got, want)
+	}
+}
+
+func TestParseBucketsRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+	}{
+		{"not a number", []string{"0.1", "fast"}},
+		{"decreasing", []string{"1", "0.5"}},
+		{"duplicate", []string{"0.5", "0.5", "1"}},
+		{"NaN", []string{"0.1", "NaN"}},
+		{"infinity", []string{"0.1", "+Inf"}},
+		{"negative infinity", []string{"-Inf", "0.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ParseBuckets(tt.values); err == nil {
+				t.Errorf("ParseBuckets(%q) = %v, want an error", tt.values, got)
+			}
+		})
+	}
+}
+
+func TestCycleMetricsAreLabelledByGroup(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg, nil)
+	m.observeCycle("", time.Second, false)
+	m.observeCycle("edge", 2*time.Minute, true)
+	m.observeCycle("edge", time.Second, false)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cycles := make(map[string]uint64)
+	overruns := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			group := metric.GetLabel()[0].GetValue()
+			switch family.GetName() {
+			case "check_cycle_seconds":
+				cycles[group] = metric.GetHistogram().GetSampleCount()
+			case "check_cycle_overruns_total":
+				overruns[group] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	if cycles[""] != 1 || cycles["edge"] != 2 {
+		t.Errorf("cycles by group = %v, want 1 top-level and 2 edge", cycles)
+	}
+	if _, ok := overruns[""]; ok || overruns["edge"] != 1 {
+		t.Errorf("overruns by group = %v, want only 1 edge", overruns)
+	}
+}
@@ -0,0 +1,1223 @@
+// Package monitor checks the health of websites and other network
+// endpoints on a schedule, keeping recent results and history, raising
+// alerts on state changes and serving the results over HTTP. It is the
+// engine behind the standalone monitor binary and can be embedded in other
+// programs.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Scheduling modes
+const (
+	// ScheduleBatch checks every site at the start of each interval
+	ScheduleBatch = "batch"
+
+	// ScheduleSpread checks sites one at a time, evenly spaced across the
+	// interval
+	ScheduleSpread = "spread"
+)
+
+// defaultCheckTimeout bounds checks of sites without their own timeout
+const defaultCheckTimeout = 5 * time.Second
+
+// checkInterval is how often sites are checked by default
+const checkInterval = 2 * time.Minute
+
+// PingResult represents the results of a website health check
+type PingResult struct {
+	Status  string `json:"status"`
+	Loss    string `json:"loss"`
+	AvgTime string `json:"avg_time"`
+	Error   string `json:"error,omitempty"`
+
+	// RequestID identifies the check's request to the endpoint, sent as
+	// X-Request-ID and as the trace ID of a W3C traceparent header
+	RequestID string `json:"request_id,omitempty"`
+
+	// DialTarget is the address connected to and PresentedHost the server
+	// name or Host header presented to it, reported when the site
+	// overrides either
+	DialTarget    string `json:"dial_target,omitempty"`
+	PresentedHost string `json:"presented_host,omitempty"`
+
+	// ServedBy is the IP address of the server that answered the check.
+	// ConnReused is set when the request went over an existing connection.
+	ServedBy   string `json:"served_by,omitempty"`
+	ConnReused bool   `json:"conn_reused,omitempty"`
+
+	// Protocol is the negotiated protocol, reported by protocol-specific
+	// checks
+	Protocol string `json:"protocol,omitempty"`
+
+	// TLSVersion is the negotiated TLS version for HTTPS checks
+	TLSVersion string `json:"tls_version,omitempty"`
+
+	// TTFBMs is the time to the first byte of the response in
+	// milliseconds, separate from AvgTime, which also covers downloading
+	// the body when it is read
+	TTFBMs float64 `json:"ttfb_ms,omitempty"`
+
+	// ClockSkewMs is how far the server's Date header is ahead of the
+	// monitor's clock (negative when behind), accurate to about a second.
+	// ClockSkewed is set when it exceeds the configured limit.
+	ClockSkewMs int  `json:"clock_skew_ms,omitempty"`
+	ClockSkewed bool `json:"clock_skewed,omitempty"`
+
+	// HandshakeTime is how long connection setup took, when measured
+	HandshakeTime string `json:"handshake_time,omitempty"`
+
+	// ContentEncoding and CompressionRatio describe the response body when
+	// compression is checked; the ratio is uncompressed size over
+	// compressed size
+	ContentEncoding  string  `json:"content_encoding,omitempty"`
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+
+	// BodyBytes is the size of the response body after decompression,
+	// when the check reads it
+	BodyBytes int `json:"body_bytes,omitempty"`
+
+	// ContentChanged is set when the body differs from the previous check
+	ContentChanged bool `json:"content_changed,omitempty"`
+
+	// ServerHeader is the response's Server header, and ServerChanged is
+	// set when it differs from the last one the site sent
+	ServerHeader  string `json:"server_header,omitempty"`
+	ServerChanged bool   `json:"server_changed,omitempty"`
+
+	// Records lists the values found by DNS record checks
+	Records []string `json:"records,omitempty"`
+
+	// Output is the combined stdout and stderr of exec checks
+	Output string `json:"output,omitempty"`
+
+	// Reason is a short machine-readable cause for distinct failure modes
+	Reason string `json:"reason,omitempty"`
+
+	// StatusCode is the HTTP status of the response, if any
+	StatusCode int `json:"status_code,omitempty"`
+
+	// Headers holds the response headers when the site captures them
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// RedirectChain lists the URLs followed when the site redirected
+	RedirectChain []string `json:"redirect_chain,omitempty"`
+
+	// SchemeChange notes a redirect between http and https: "upgraded to
+	// https", or "downgraded to http" if any hop left https
+	SchemeChange string `json:"scheme_change,omitempty"`
+
+	// ConsecutiveFailures counts the site's failed checks in a row
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+
+	// CurrentDowntime is how long the site has been failing, and
+	// LastDowntime how long its previous run of failures lasted
+	CurrentDowntime string `json:"current_downtime,omitempty"`
+	LastDowntime    string `json:"last_downtime,omitempty"`
+
+	// Assertions reports each of the check's assertions that was evaluated
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+
+	// GoldenDiff lists how the response's structure differs from the
+	// site's golden snapshot
+	GoldenDiff []string `json:"golden_diff,omitempty"`
+
+	// FailureKind tells whether a failure is retryable or deterministic
+	FailureKind string `json:"failure_kind,omitempty"`
+
+	// Paths holds per-path results for sites checking several paths
+	Paths map[string]PingResult `json:"paths,omitempty"`
+
+	// BurnRate reports error budget consumption when an SLO is configured
+	BurnRate *BurnRate `json:"burn_rate,omitempty"`
+
+	// LatencySLA reports each of the site's latency SLAs
+	LatencySLA []SLAStatus `json:"latency_sla,omitempty"`
+
+	// Warmup is set when the check ran with the site's warmup timeout
+	// because the previous check failed
+	Warmup bool `json:"warmup,omitempty"`
+
+	// Self marks checks of the monitor's own listen address
+	Self bool `json:"self,omitempty"`
+
+	// Name is the site's configured display name
+	Name string `json:"name,omitempty"`
+
+	// Note is an operator annotation set through the API
+	Note string `json:"note,omitempty"`
+
+	// CheckedAt is when the check completed
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+
+	// Duration is the measured latency of the check
+	Duration time.Duration `json:"-"`
+}
+
+// WebsiteMonitor manages website health checking
+type WebsiteMonitor struct {
+	websites []SiteConfig
+	checkers map[string]Checker
+	results  map[string]PingResult
+	history  map[string]*siteHistory
+
+	// samples is the number of samples held across all histories, and
+	// evictions tracks those dropped to stay within MaxSamples
+	samples   int
+	evictions struct {
+		queue evictionQueue
+		count uint64
+	}
+	burning map[string]bool
+
+	// warming holds sites with a warmup timeout whose last check failed
+	warming map[string]bool
+
+	// version counts changes to what GetResults returns, and pingCache
+	// holds the /ping response serialized at some version
+	version   uint64
+	pingCache pingCache
+
+	// paused stops all checks and alerts while set
+	paused bool
+
+	// notes holds operator annotations per site
+	notes map[string]string
+
+	// streaks tracks consecutive outcomes for down/up alerting
+	streaks map[string]*streak
+
+	// slaBreached records which latency SLAs each site is breaching
+	slaBreached map[string][]bool
+	mu          sync.RWMutex
+
+	// awaitingFirst holds sites added at runtime that have not produced a
+	// result yet
+	awaitingFirst map[string]bool
+
+	// running holds sites with a check in flight, and checkTime how long
+	// each site's last check took, used to schedule fast sites first
+	running   map[string]chan struct{}
+	checkTime map[string]time.Duration
+
+	// hooks are the callbacks registered with OnResult. Results wait in
+	// hookQueue for a single delivering goroutine, running while
+	// delivering is set, so hooks see each site's results in order.
+	hooks      []func(site string, result PingResult)
+	hookQueue  []hookEvent
+	delivering bool
+
+	// slots limits concurrent checks when MaxConcurrent is set, and
+	// hostSlots the checks of each host when MaxPerHost is
+	slots     chan struct{}
+	hostSlots map[string]chan struct{}
+
+	// In-flight checks run under checkCtx so they can outlive the
+	// scheduling loop during a graceful shutdown
+	checkCtx     context.Context
+	cancelChecks context.CancelFunc
+	loopDone     chan struct{}
+	wg           sync.WaitGroup
+	inflight     atomic.Int64
+
+	// initialDone is closed once the first check cycle has finished
+	initialDone chan struct{}
+
+	// cyclesRunning counts the cycles whose checks are still running;
+	// lastCycle is how long the last cycle took and overruns how many
+	// took longer than Interval
+	cyclesRunning int
+	lastCycle     time.Duration
+	overruns      uint64
+
+	// stopping is set once Shutdown begins, after which no checks start
+	stopping bool
+
+	// DefaultHeaders are sent with every check; per-site headers of the
+	// same name take precedence
+	DefaultHeaders map[string]string
+
+	// SourceIP is the local address checks are sent from unless a site
+	// sets its own
+	SourceIP string
+
+	// Interval is how often every site is checked
+	Interval time.Duration
+
+	// TotalTimeout bounds each check of sites without their own timeout,
+	// across all attempts and retry delays. RequestTimeout bounds a single
+	// attempt of sites without their own request timeout; 0 leaves
+	// attempts bounded only by what remains of the total. The total is
+	// the ceiling either way: retries never extend a check beyond it.
+	TotalTimeout   time.Duration
+	RequestTimeout time.Duration
+
+	// StatusHistorySize is the number of check outcomes kept per site for
+	// uptime and burn rates
+	StatusHistorySize int
+
+	// LatencyHistorySize is the number of successful check latencies kept
+	// per site for latency percentiles
+	LatencyHistorySize int
+
+	// MaxSamples caps the samples held across all sites, evicting the
+	// oldest first; 0 is unlimited
+	MaxSamples int
+
+	// HistoryRetention is how far back imported samples may reach; 0
+	// accepts any age
+	HistoryRetention time.Duration
+
+	// SLO enables burn-rate alerting when set
+	SLO *SLOConfig
+
+	// Schedule is ScheduleBatch (the default) or ScheduleSpread
+	Schedule string
+
+	// Shuffle, when set, randomizes the order sites are checked in each
+	// cycle. It is only used by the scheduling loop.
+	Shuffle *rand.Rand
+
+	// FreshnessWindow is the maximum result age /ping?fresh=true accepts
+	// before checking again, and FreshTimeout how long it waits for the
+	// new results
+	FreshnessWindow time.Duration
+	FreshTimeout    time.Duration
+
+	// MaxConcurrent limits how many checks run at once; 0 runs every
+	// site's check concurrently
+	MaxConcurrent int
+
+	// SkipOverrun skips a scheduled cycle while the previous one is still
+	// running, instead of starting it alongside
+	SkipOverrun bool
+
+	// MaxPerHost limits how many checks of the same host run at once, so
+	// many endpoints on one server don't overwhelm it; 0 is unlimited
+	MaxPerHost int
+
+	// PingCacheTTL is how long a serialized /ping response may be reused
+	// while the results are unchanged; 0 serializes every request
+	PingCacheTTL time.Duration
+
+	// Overall configures how results roll up into the overall status
+	Overall OverallThresholds
+
+	// SelfAddrs are the monitor's own listen addresses. Sites pointing back
+	// at them are checked with SelfTimeout instead of TotalTimeout, only
+	// alert when SelfAlerts is set and are left out of the overall status.
+	SelfAddrs   []string
+	SelfTimeout time.Duration
+	SelfAlerts  bool
+
+	// Alerter receives alerts about site state changes
+	Alerter Alerter
+
+	// DedupKey renders each alert's dedup key from the site's URL (.Site)
+	// and hostname (.Host); nil uses the URL
+	DedupKey *template.Template
+
+	// Pusher receives a snapshot of all results after each cycle when set
+	Pusher *Pusher
+
+	// Exporters also receive the snapshot after each cycle
+	Exporters []Exporter
+
+	// Metrics receives check observations when set
+	Metrics *Metrics
+
+	// Group labels the monitor's cycle metrics; empty for the top-level
+	// sites
+	Group string
+
+	// AllowEmpty skips the warning about having no sites, for a top-level
+	// monitor whose config keeps all of its sites in groups
+	AllowEmpty bool
+
+	// Clock is the time source for scheduling and timestamps
+	Clock Clock
+
+	// Location is the timezone of timestamps in human-facing output such
+	// as /ping.txt; JSON is always UTC. Nil means UTC.
+	Location *time.Location
+}
+
+// NewWebsiteMonitor creates a new monitor with the given websites
+func NewWebsiteMonitor(websites []SiteConfig) *WebsiteMonitor {
+	return &WebsiteMonitor{
+		websites: websites,
+		checkers: map[string]Checker{
+			CheckHTTP:      &HTTPChecker{},
+			CheckTCP:       TCPChecker{},
+			CheckDNS:       DNSChecker{},
+			CheckWebSocket: &WebSocketChecker{},
+			CheckHTTP3:     &HTTP3Checker{},
+		},
+		results:       make(map[string]PingResult),
+		history:       make(map[string]*siteHistory),
+		burning:       make(map[string]bool),
+		warming:       make(map[string]bool),
+		slaBreached:   make(map[string][]bool),
+		streaks:       make(map[string]*streak),
+		notes:         make(map[string]string),
+		awaitingFirst: make(map[string]bool),
+		running:       make(map[string]chan struct{}),
+		checkTime:     make(map[string]time.Duration),
+		hostSlots:     make(map[string]chan struct{}),
+
+		Interval:           checkInterval,
+		TotalTimeout:       defaultCheckTimeout,
+		FreshnessWindow:    time.Minute,
+		FreshTimeout:       8 * time.Second,
+		StatusHistorySize:  1000,
+		LatencyHistorySize: 1000,
+		HistoryRetention:   30 * 24 * time.Hour,
+		Alerter:            LogAlerter{},
+		Clock:              realClock{},
+	}
+}
+
+// RegisterChecker sets the checker used for the given check type,
+// replacing any existing one
+func (wm *WebsiteMonitor) RegisterChecker(checkType string, c Checker) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.checkers[checkType] = c
+}
+
+// StartMonitoring begins continuous checking of websites until ctx is
+// cancelled. Checks already running at that point are left to finish; use
+// Shutdown to wait for them.
+func (wm *WebsiteMonitor) StartMonitoring(ctx context.Context) {
+	wm.mu.Lock()
+	wm.checkCtx, wm.cancelChecks = context.WithCancel(context.WithoutCancel(ctx))
+	wm.loopDone = make(chan struct{})
+	wm.initialDone = make(chan struct{})
+	if wm.MaxConcurrent > 0 {
+		wm.slots = make(chan struct{}, wm.MaxConcurrent)
+	}
+	for _, site := range wm.websites {
+		wm.warnIfSelf(site)
+	}
+	wm.mu.Unlock()
+
+	go func() {
+		defer close(wm.loopDone)
+
+		ticker := wm.Clock.NewTicker(wm.Interval)
+		defer ticker.Stop()
+
+		// Do an initial check of all sites
+		initial := wm.runCycle(ctx.Done(), wm.Schedule == ScheduleSpread)
+		go func() {
+			<-initial
+			close(wm.initialDone)
+		}()
+
+		for {
+			select {
+			case <-ticker.C():
+				wm.mu.RLock()
+				overlapping := wm.cyclesRunning > 0
+				wm.mu.RUnlock()
+				if overlapping && wm.SkipOverrun {
+					log.Println("Skipping check cycle: the previous cycle is still running")
+					continue
+				}
+				wm.runCycle(ctx.Done(), wm.Schedule == ScheduleSpread)
+			case <-ctx.Done():
+				log.Println("Monitoring stopped")
+				return
+			}
+		}
+	}()
+}
+
+// runCycle starts a check cycle, spread across the interval if spread is
+// set, and returns a channel closed once all of its checks are recorded.
+// Cycles whose checks take longer than the interval, not counting the time
+// spent deliberately spreading them out, are counted and logged, as they
+// mean the monitor can't keep up.
+func (wm *WebsiteMonitor) runCycle(stop <-chan struct{}, spread bool) <-chan struct{} {
+	wm.mu.Lock()
+	wm.cyclesRunning++
+	wm.mu.Unlock()
+
+	start := wm.Clock.Now()
+	done, spreading := wm.checkAllSites(wm.checkCtx, stop, spread)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for _, d := range done {
+			<-d
+		}
+		elapsed := wm.Clock.Now().Sub(start)
+		overrun := elapsed-spreading > wm.Interval
+
+		wm.mu.Lock()
+		wm.cyclesRunning--
+		wm.lastCycle = elapsed
+		if overrun {
+			wm.overruns++
+		}
+		overruns := wm.overruns
+		wm.mu.Unlock()
+
+		wm.Metrics.observeCycle(wm.Group, elapsed, overrun)
+		if overrun {
+			log.Printf("WARNING: check cycle took %s, longer than the %s interval (%d overrun(s) so far); "+
+				"consider raising the interval or -max-concurrent-checks", elapsed.Round(time.Millisecond), wm.Interval, overruns)
+		}
+	}()
+	return finished
+}
+
+// WaitInitial blocks until the first check of every site has been
+// recorded, or ctx expires. In spread mode that takes up to an interval.
+func (wm *WebsiteMonitor) WaitInitial(ctx context.Context) error {
+	wm.mu.RLock()
+	initial := wm.initialDone
+	wm.mu.RUnlock()
+	if initial == nil {
+		return errors.New("monitoring has not started")
+	}
+
+	select {
+	case <-initial:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown waits for the monitoring loop to stop and in-flight checks to
+// finish. If ctx expires first, the remaining checks are cancelled and the
+// number that were still running is returned.
+func (wm *WebsiteMonitor) Shutdown(ctx context.Context) int {
+	if wm.loopDone == nil {
+		return 0
+	}
+
+	wm.mu.Lock()
+	wm.stopping = true
+	wm.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		<-wm.loopDone
+		wm.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+		running := int(wm.inflight.Load())
+		wm.cancelChecks()
+		return running
+	}
+}
+
+// checkAllSites performs health checks on all enabled websites. Checks
+// interrupted by ctx being cancelled are recorded as "cancelled" rather than
+// failed so they don't count against the site, and sites inside a
+// maintenance window are recorded as "maintenance". Sites whose last check
+// was quickest are dispatched first so they don't queue behind slow ones,
+// unless Shuffle is set. With spread set sites are dispatched one per slot
+// instead, stopping early once stop is closed. It returns a channel for
+// each check started, closed once its result is recorded, and how long
+// was spent waiting for spread slots.
+func (wm *WebsiteMonitor) checkAllSites(ctx context.Context, stop <-chan struct{}, spread bool) ([]<-chan struct{}, time.Duration) {
+	if wm.Paused() {
+		log.Println("Monitoring paused, skipping check cycle")
+		return nil, 0
+	}
+
+	all := wm.Sites()
+	if len(all) == 0 {
+		if !wm.AllowEmpty {
+			log.Println("WARNING: no sites configured, nothing to check")
+		}
+		return nil, 0
+	}
+
+	var sites []SiteConfig
+	for _, site := range all {
+		if site.enabled() {
+			sites = append(sites, site)
+		}
+	}
+
+	// Spread mode gives each site its own slot of the interval, in a fixed
+	// order so every site stays one interval apart from its last check
+	var slot Ticker
+	if spread && len(sites) > 1 {
+		slot = wm.Clock.NewTicker(wm.Interval / time.Duration(len(sites)))
+		defer slot.Stop()
+	}
+	if wm.Shuffle != nil {
+		wm.Shuffle.Shuffle(len(sites), func(i, j int) { sites[i], sites[j] = sites[j], sites[i] })
+	} else if slot == nil {
+		wm.mu.RLock()
+		sort.SliceStable(sites, func(i, j int) bool {
+			return wm.checkTime[sites[i].URL] < wm.checkTime[sites[j].URL]
+		})
+		wm.mu.RUnlock()
+	}
+
+	var done []<-chan struct{}
+	var spreading time.Duration
+	for i, site := range sites {
+		if slot != nil && i > 0 {
+			waitStart := wm.Clock.Now()
+			select {
+			case <-slot.C():
+			case <-stop:
+				return done, spreading
+			}
+			spreading += wm.Clock.Now().Sub(waitStart)
+		}
+		if d := wm.startCheck(ctx, site, site.inMaintenance(wm.Clock.Now())); d != nil {
+			done = append(done, d)
+		}
+	}
+
+	if wm.Pusher != nil || len(wm.Exporters) > 0 {
+		go func() {
+			for _, d := range done {
+				<-d
+			}
+			snapshot := wm.snapshot()
+			if wm.Pusher != nil {
+				pushed := snapshot
+				if wm.Pusher.HistorySamples > 0 {
+					pushed.History = wm.recentHistory(wm.Pusher.HistorySamples)
+				}
+				wm.Pusher.Push(pushed)
+			}
+			for _, e := range wm.Exporters {
+				e.Export(snapshot)
+			}
+		}()
+	}
+
+	return done, spreading
+}
+
+// startCheck checks a site in the background and records the result,
+// marking it as taken during maintenance if requested. A site whose
+// previous check is still running is skipped, so a slow site never holds
+// more than one check slot. When checks are limited, startCheck blocks
+// until a slot is free; when checks per host are limited too, the check
+// then waits in the background for its host. The returned channel is
+// closed once the result is recorded; if the site was skipped it is the
+// running check's channel, and it is nil if no check could be started.
+func (wm *WebsiteMonitor) startCheck(ctx context.Context, site SiteConfig, maintenance bool) <-chan struct{} {
+	wm.mu.Lock()
+	if wm.paused || wm.stopping {
+		wm.mu.Unlock()
+		return nil
+	}
+	if running, ok := wm.running[site.URL]; ok {
+		wm.mu.Unlock()
+		log.Printf("Skipping %s: previous check still running", site.URL)
+		return running
+	}
+	done := make(chan struct{})
+	wm.running[site.URL] = done
+	slots := wm.slots
+	// Counted under the lock so Shutdown either waits for the check or
+	// stops it starting
+	wm.wg.Add(1)
+	wm.mu.Unlock()
+
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wm.mu.Lock()
+			delete(wm.running, site.URL)
+			wm.mu.Unlock()
+			close(done)
+			wm.wg.Done()
+			return nil
+		}
+	}
+
+	wm.inflight.Add(1)
+	go func() {
+		defer wm.wg.Done()
+		defer close(done)
+		defer wm.inflight.Add(-1)
+
+		var result PingResult
+		var elapsed time.Duration
+		release, err := wm.acquireHost(ctx, site, slots)
+		if err != nil {
+			result = cancelledResult(err)
+		} else {
+			defer release()
+
+			log.Printf("Checking %s...", site.URL)
+			start := wm.Clock.Now()
+			result = wm.checkSite(ctx, site)
+			elapsed = wm.Clock.Now().Sub(start)
+			if result.Status == "failed" && ctx.Err() != nil {
+				result = cancelledResult(ctx.Err())
+			} else if maintenance {
+				result = maintenanceResult(result)
+			}
+		}
+		result.CheckedAt = wm.Clock.Now().UTC()
+
+		wm.mu.Lock()
+		delete(wm.running, site.URL)
+		if err == nil {
+			wm.checkTime[site.URL] = elapsed
+		}
+		wm.mu.Unlock()
+
+		wm.recordResult(site, result)
+
+		requestID := ""
+		if result.RequestID != "" {
+			requestID = ", Request ID: " + result.RequestID
+		}
+		log.Printf("%s check for %s - Status: %s, Loss: %s, Avg time: %s%s",
+			strings.ToUpper(site.Type()), site.URL, result.Status, result.Loss, result.AvgTime, requestID)
+	}()
+
+	return done
+}
+
+// acquireHost takes the slot of site's host, if checks per host are
+// limited, for a check holding a slot of global when that is non-nil. A
+// check that has to wait for its host gives its global slot back
+// meanwhile, so checks of other hosts aren't held up, and takes one again
+// once the host is free. The returned func releases every slot held; on
+// error, when ctx ends first, none are held.
+func (wm *WebsiteMonitor) acquireHost(ctx context.Context, site SiteConfig, global chan struct{}) (func(), error) {
+	releaseGlobal := func() {
+		if global != nil {
+			<-global
+		}
+	}
+
+	host := wm.hostSlot(site)
+	if host == nil {
+		return releaseGlobal, nil
+	}
+	release := func() {
+		<-host
+		releaseGlobal()
+	}
+
+	select {
+	case host <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	releaseGlobal()
+	select {
+	case host <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if global != nil {
+		select {
+		case global <- struct{}{}:
+		case <-ctx.Done():
+			<-host
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// hostSlot returns the semaphore limiting checks of site's host, or nil
+// when checks per host are unlimited
+func (wm *WebsiteMonitor) hostSlot(site SiteConfig) chan struct{} {
+	if wm.MaxPerHost <= 0 {
+		return nil
+	}
+	host, _ := siteHostPort(site)
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return nil
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	slot, ok := wm.hostSlots[host]
+	if !ok {
+		slot = make(chan struct{}, wm.MaxPerHost)
+		wm.hostSlots[host] = slot
+	}
+	return slot
+}
+
+// Sites returns the currently configured sites
+func (wm *WebsiteMonitor) Sites() []SiteConfig {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return append([]SiteConfig(nil), wm.websites...)
+}
+
+// AddSite starts monitoring a new site. If monitoring is running the site
+// is checked straight away, and a "first_result" event confirms when its
+// first result arrives. Exec checks run commands, so they can only come
+// from the config file.
+func (wm *WebsiteMonitor) AddSite(site SiteConfig) error {
+	if site.Type() == CheckExec {
+		return fmt.Errorf("exec checks can only be configured in the config file")
+	}
+	if err := validateSite(&site); err != nil {
+		return err
+	}
+	if err := wm.validateTimeouts(site); err != nil {
+		return err
+	}
+
+	wm.mu.Lock()
+	if _, ok := wm.checkers[site.Type()]; !ok {
+		wm.mu.Unlock()
+		return fmt.Errorf("unsupported check type %q", site.Type())
+	}
+	for _, existing := range wm.websites {
+		if existing.URL == site.URL {
+			wm.mu.Unlock()
+			return fmt.Errorf("site %s is already monitored", site.URL)
+		}
+	}
+	wm.websites = append(wm.websites, site)
+	wm.awaitingFirst[site.URL] = true
+	wm.version++
+	checkCtx := wm.checkCtx
+	wm.mu.Unlock()
+
+	log.Printf("Added site %s", site.URL)
+	wm.warnIfSelf(site)
+	if checkCtx != nil && site.enabled() {
+		wm.startCheck(checkCtx, site, site.inMaintenance(wm.Clock.Now()))
+	}
+
+	return nil
+}
+
+// recordResult stores a completed check, appends it to the site's history
+// and raises any alerts its derived state calls for, unless monitoring has
+// been paused since the check started. Only successes and
+// failures, slow or not, enter the history; other outcomes, such as cancelled checks,
+// maintenance or mapped statuses like "draining", are kept out so they
+// don't skew reliability figures.
+func (wm *WebsiteMonitor) recordResult(site SiteConfig, result PingResult) {
+	var alerts []Alert
+
+	wm.mu.Lock()
+	if healthy(result.Status) || result.Status == "failed" {
+		h, ok := wm.history[site.URL]
+		if !ok {
+			h = wm.newSiteHistory()
+			wm.history[site.URL] = h
+		}
+		wm.samples += h.add(Sample{Time: result.CheckedAt, Status: result.Status, Duration: result.Duration})
+		wm.queue(h)
+		wm.enforceSampleCap()
+
+		if wm.SLO != nil {
+			result.BurnRate = wm.SLO.evaluate(h.status, result.CheckedAt)
+			if result.BurnRate.Alerting != wm.burning[site.URL] {
+				wm.burning[site.URL] = result.BurnRate.Alerting
+				alerts = append(alerts, burnRateAlert(site.URL, result))
+			}
+		}
+
+		if site.WarmupTimeout > 0 {
+			if result.Status == "failed" && !wm.warming[site.URL] {
+				wm.warming[site.URL] = true
+				log.Printf("%s failed, allowing %s for its next check", site.URL, site.WarmupTimeout)
+			} else if healthy(result.Status) && result.Duration <= wm.effectiveSite(site).timeout() {
+				delete(wm.warming, site.URL)
+			}
+		}
+
+		st, ok := wm.streaks[site.URL]
+		if !ok {
+			st = &streak{}
+			wm.streaks[site.URL] = st
+		}
+		if alert, ok := st.record(site, result); ok {
+			alerts = append(alerts, alert)
+		}
+		st.apply(&result)
+
+		breached := wm.slaBreached[site.URL]
+		if len(breached) != len(site.LatencySLA) {
+			breached = make([]bool, len(site.LatencySLA))
+			wm.slaBreached[site.URL] = breached
+		}
+		for i, rule := range site.LatencySLA {
+			status := rule.evaluate(h.latency, result.CheckedAt)
+			result.LatencySLA = append(result.LatencySLA, status)
+			if status.Breached != breached[i] {
+				breached[i] = status.Breached
+				alerts = append(alerts, slaAlert(site.URL, status, result.CheckedAt))
+			}
+		}
+	}
+	if result.ContentChanged && site.AlertOnContentChange {
+		alerts = append(alerts, Alert{
+			Site:    site.URL,
+			Event:   "content_changed",
+			Message: "Response body changed since the previous check",
+			Time:    result.CheckedAt,
+		})
+	}
+	if wm.awaitingFirst[site.URL] {
+		delete(wm.awaitingFirst, site.URL)
+		alerts = append(alerts, Alert{
+			Site:    site.URL,
+			Event:   "first_result",
+			Message: fmt.Sprintf("Newly added site produced its first result: %s", result.Status),
+			Time:    result.CheckedAt,
+		})
+	}
+	for i := range alerts {
+		alerts[i].Name = site.Name
+		alerts[i].Severity = alertSeverity(site, alerts[i].Event)
+	}
+	result.Name = site.Name
+	wm.results[site.URL] = result
+	wm.version++
+	deliver := false
+	if len(wm.hooks) > 0 {
+		wm.hookQueue = append(wm.hookQueue, hookEvent{site: site.URL, result: result, hooks: wm.hooks})
+		deliver = !wm.delivering
+		wm.delivering = true
+	}
+	if wm.paused || (result.Self && !wm.SelfAlerts) {
+		alerts = nil
+	}
+	wm.mu.Unlock()
+
+	wm.Metrics.observe(site.URL, result)
+
+	if deliver {
+		go wm.deliverHooks()
+	}
+
+	for _, alert := range alerts {
+		wm.sendAlert(alert)
+	}
+}
+
+// Pause stops all checks and alerts until Resume is called. Checks already
+// running finish and record their results without alerting.
+func (wm *WebsiteMonitor) Pause() {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.paused = true
+	wm.version++
+}
+
+// Resume restarts monitoring after Pause, checking every site straight away
+func (wm *WebsiteMonitor) Resume() {
+	wm.mu.Lock()
+	wasPaused := wm.paused
+	wm.paused = false
+	wm.version++
+	started := wm.checkCtx != nil && !wm.stopping
+	overlapping := wm.cyclesRunning > 0
+	wm.mu.Unlock()
+
+	if !wasPaused || !started {
+		return
+	}
+	if overlapping && wm.SkipOverrun {
+		log.Println("Skipping resume check cycle: the previous cycle is still running")
+		return
+	}
+	// Check everything straight away rather than waiting out spread slots
+	go wm.runCycle(nil, false)
+}
+
+// Paused reports whether monitoring is paused
+func (wm *WebsiteMonitor) Paused() bool {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.paused
+}
+
+// hookEvent is a stored result waiting for the hooks registered when it
+// was recorded
+type hookEvent struct {
+	site   string
+	result PingResult
+	hooks  []func(site string, result PingResult)
+}
+
+// deliverHooks passes queued results to their hooks in the order they were
+// recorded, until the queue is empty
+func (wm *WebsiteMonitor) deliverHooks() {
+	for {
+		wm.mu.Lock()
+		if len(wm.hookQueue) == 0 {
+			wm.delivering = false
+			wm.mu.Unlock()
+			return
+		}
+		event := wm.hookQueue[0]
+		wm.hookQueue[0] = hookEvent{}
+		wm.hookQueue = wm.hookQueue[1:]
+		wm.mu.Unlock()
+
+		for _, hook := range event.hooks {
+			runHook(hook, event.site, event.result)
+		}
+	}
+}
+
+// runHook calls a result hook, logging a panic rather than letting it skip
+// the hooks after it
+func runHook(hook func(site string, result PingResult), site string, result PingResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Result hook for %s panicked: %v\n%s", site, r, debug.Stack())
+		}
+	}()
+	hook(site, result)
+}
+
+// OnResult registers fn to be called with every result once it is stored.
+// Hooks run in the background, in registration order and one result at a
+// time in the order results are stored, so a slow hook never delays checks.
+func (wm *WebsiteMonitor) OnResult(fn func(site string, result PingResult)) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.hooks = append(wm.hooks[:len(wm.hooks):len(wm.hooks)], fn)
+}
+
+// checkSite runs the checker registered for the site's check type, allowing
+// the warmup timeout if the site's last check failed
+func (wm *WebsiteMonitor) checkSite(ctx context.Context, site SiteConfig) PingResult {
+	wm.mu.RLock()
+	checker, ok := wm.checkers[site.Type()]
+	wm.mu.RUnlock()
+
+	if !ok {
+		return failedResult(fmt.Sprintf("Unknown check type %q", site.Type()))
+	}
+
+	site = wm.effectiveSite(site)
+	ctx = withClock(ctx, wm.Clock)
+
+	wm.mu.RLock()
+	warmup := wm.warming[site.URL]
+	wm.mu.RUnlock()
+	if warmup {
+		// A cold start may need the whole extended timeout in one attempt
+		site.Timeout = max(site.WarmupTimeout, site.Timeout)
+		site.RequestTimeout = 0
+	}
+
+	var result PingResult
+	if len(site.Paths) > 0 {
+		result = checkPaths(ctx, checker, site)
+	} else {
+		result = runCheck(ctx, checker, site)
+	}
+	result.Self = wm.isSelf(site)
+	result.Warmup = warmup
+	if result.Status == "success" && site.SlowThresholdMs > 0 && result.Duration > site.slowThreshold() {
+		result.Status = "slow"
+	}
+	return result
+}
+
+// effectiveSite applies the monitor-wide defaults to site
+func (wm *WebsiteMonitor) effectiveSite(site SiteConfig) SiteConfig {
+	site.Headers = mergeHeaders(wm.DefaultHeaders, site.Headers)
+	if site.SourceIP == "" {
+		site.SourceIP = wm.SourceIP
+	}
+	if site.Timeout == 0 && wm.SelfTimeout > 0 && wm.isSelf(site) {
+		site.Timeout = Duration(wm.SelfTimeout)
+	}
+	if site.Timeout == 0 {
+		site.Timeout = Duration(wm.TotalTimeout)
+	}
+	if site.RequestTimeout == 0 {
+		site.RequestTimeout = Duration(wm.RequestTimeout)
+	}
+	return site
+}
+
+// validateTimeouts checks a site's settings that depend on its effective
+// timeout, which may come from -total-timeout rather than the site itself
+func (wm *WebsiteMonitor) validateTimeouts(site SiteConfig) error {
+	site = wm.effectiveSite(site)
+	if site.slowThreshold() >= site.timeout() {
+		return fmt.Errorf("site %s: invalid slow_threshold_ms %v: must be below the timeout %s", site.URL, site.SlowThresholdMs, site.timeout())
+	}
+	if site.WarmupTimeout > 0 && time.Duration(site.WarmupTimeout) <= site.timeout() {
+		return fmt.Errorf("site %s: invalid warmup_timeout %s: must be longer than the timeout %s", site.URL, site.WarmupTimeout, site.timeout())
+	}
+	return nil
+}
+
+// ValidateSites checks the monitored sites against the monitor's settings,
+// such as its default timeouts. Call it once those settings are in place.
+func (wm *WebsiteMonitor) ValidateSites() error {
+	for _, site := range wm.Sites() {
+		if err := wm.validateTimeouts(site); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCheck runs a single check bounded by the site's timeout. A checker
+// that panics fails the check with an "internal error" reason instead of
+// taking the process down.
+func runCheck(ctx context.Context, checker Checker, site SiteConfig) (result PingResult) {
+	ctx, cancel := context.WithTimeout(ctx, site.timeout())
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Check of %s panicked: %v\n%s", site.URL, r, debug.Stack())
+			result = failedResult(fmt.Sprintf("Internal error: %v", r))
+			result.Reason = "internal error"
+		}
+	}()
+
+	return checker.Check(ctx, site)
+}
+
+// Reset clears stored results and history, and what checkers remember of
+// earlier responses, either for every site or only for sites matching host,
+// returning how many entries were removed
+func (wm *WebsiteMonitor) Reset(host string) int {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	wm.version++
+	cleared := 0
+	for site := range wm.results {
+		if host == "" || matchesHost(site, host) {
+			delete(wm.results, site)
+			cleared++
+		}
+	}
+	for site := range wm.history {
+		if host == "" || matchesHost(site, host) {
+			wm.samples -= wm.history[site].clear()
+			delete(wm.history, site)
+			delete(wm.burning, site)
+			delete(wm.warming, site)
+			delete(wm.slaBreached, site)
+			delete(wm.streaks, site)
+		}
+	}
+	for _, checker := range wm.checkers {
+		if r, ok := checker.(resetter); ok {
+			r.Reset(host)
+		}
+	}
+
+	return cleared
+}
+
+// GetResults returns the current monitoring results, with disabled sites
+// reported as "disabled"
+func (wm *WebsiteMonitor) GetResults() map[string]PingResult {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	// Create a copy to avoid external modification
+	resultsCopy := make(map[string]PingResult, len(wm.results))
+	for k, v := range wm.results {
+		v.Note = wm.notes[k]
+		resultsCopy[k] = v
+	}
+	for _, site := range wm.websites {
+		if !site.enabled() {
+			resultsCopy[site.URL] = PingResult{Status: "disabled", Name: site.Name, Note: wm.notes[site.URL]}
+		}
+	}
+
+	return resultsCopy
+}
+
+// Refresh checks every site whose latest result is older than maxAge, or
+// that has no result yet, and waits for those checks until ctx expires. It
+// does nothing before monitoring has started.
+func (wm *WebsiteMonitor) Refresh(ctx context.Context, maxAge time.Duration) {
+	wm.mu.RLock()
+	checkCtx := wm.checkCtx
+	wm.mu.RUnlock()
+	if checkCtx == nil {
+		return
+	}
+
+	results := wm.GetResults()
+	now := wm.Clock.Now()
+	var done []<-chan struct{}
+	for _, site := range wm.Sites() {
+		if !site.enabled() {
+			continue
+		}
+		if result, ok := results[site.URL]; ok && now.Sub(result.CheckedAt) <= maxAge {
+			continue
+		}
+		if d := wm.startCheck(checkCtx, site, site.inMaintenance(now)); d != nil {
+			done = append(done, d)
+		}
+	}
+
+	for _, d := range done {
+		select {
+		case <-d:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SetNote attaches a note to every site matching host, or clears it when
+// note is empty, returning how many sites matched
+func (wm *WebsiteMonitor) SetNote(host, note string) int {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	wm.version++
+	matched := 0
+	for _, site := range wm.websites {
+		if !matchesHost(site.URL, host) {
+			continue
+		}
+		if note == "" {
+			delete(wm.notes, site.URL)
+		} else {
+			wm.notes[site.URL] = note
+		}
+		matched++
+	}
+
+	return matched
+}
@@ -0,0 +1,348 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSlowSiteDoesNotStarveFastSites checks that fast sites keep being
+// checked within each cycle while a slow site holds a check slot
+func TestSlowSiteDoesNotStarveFastSites(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	sites := []SiteConfig{{URL: slow.URL + "/slow", Timeout: Duration(time.Minute)}}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		sites = append(sites, SiteConfig{URL: fast.URL + path})
+	}
+
+	wm := NewWebsiteMonitor(sites)
+	wm.Interval = time.Hour
+	wm.MaxConcurrent = 2
+	results := make(chan string, 100)
+	wm.OnResult(func(site string, result PingResult) { results <- site })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wm.StartMonitoring(ctx)
+
+	// waitFast waits for every fast site to be checked once
+	waitFast := func(cycle string) {
+		t.Helper()
+		pending := map[string]bool{}
+		for _, site := range sites[1:] {
+			pending[site.URL] = true
+		}
+		timeout := time.After(5 * time.Second)
+		for len(pending) > 0 {
+			select {
+			case site := <-results:
+				if site == sites[0].URL {
+					t.Fatalf("%s: the slow site finished while it should still be blocked", cycle)
+				}
+				delete(pending, site)
+			case <-timeout:
+				t.Fatalf("%s: fast sites %v weren't checked while the slow site was running", cycle, pending)
+			}
+		}
+	}
+
+	// The slow site is dispatched first but only holds one of the two slots
+	waitFast("first cycle")
+
+	// Next cycle the slow site is still running, so it is skipped rather
+	// than taking a slot, and the fast sites go first
+	wm.runCycle(ctx.Done(), false)
+	waitFast("second cycle")
+
+	cancel()
+}
+
+// TestBusyHostDoesNotHoldGlobalSlots checks that checks queued behind
+// their host's limit don't keep other hosts from the global pool, and are
+// recorded as cancelled if monitoring stops while they wait
+func TestBusyHostDoesNotHoldGlobalSlots(t *testing.T) {
+	release := make(chan struct{})
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer busy.Close()
+	defer close(release)
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	sites := []SiteConfig{
+		{URL: busy.URL + "/a", Timeout: Duration(time.Minute)},
+		{URL: busy.URL + "/b", Timeout: Duration(time.Minute)},
+		{URL: busy.URL + "/c", Timeout: Duration(time.Minute)},
+		{URL: otherURL},
+	}
+
+	wm := NewWebsiteMonitor(sites)
+	wm.Interval = time.Hour
+	wm.MaxConcurrent = 2
+	wm.MaxPerHost = 1
+	results := make(chan SiteResult, 10)
+	wm.OnResult(func(site string, result PingResult) { results <- SiteResult{site, result} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wm.StartMonitoring(ctx)
+
+	select {
+	case sr := <-results:
+		if sr.Site != otherURL {
+			t.Fatalf("%s finished first, want %s", sr.Site, otherURL)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a check of another host was starved by checks waiting for a busy host")
+	}
+
+	// Stopping cancels the checks still waiting for the busy host
+	wm.cancelChecks()
+	cancelled := 0
+	timeout := time.After(5 * time.Second)
+	for cancelled < 2 {
+		select {
+		case sr := <-results:
+			if sr.Result.Status != "cancelled" {
+				t.Fatalf("%s: status %q, want cancelled", sr.Site, sr.Result.Status)
+			}
+			cancelled++
+		case <-timeout:
+			t.Fatalf("only %d waiting checks were cancelled, want 2", cancelled)
+		}
+	}
+}
+
+func TestSlowThresholdUsesEffectiveTimeout(t *testing.T) {
+	site := SiteConfig{URL: "https://example.com", SlowThresholdMs: 8000}
+	if err := validateSite(&site); err != nil {
+		t.Fatalf("validateSite rejected a threshold without a site timeout: %v", err)
+	}
+
+	wm := NewWebsiteMonitor([]SiteConfig{site})
+	wm.TotalTimeout = 30 * time.Second
+	if err := wm.ValidateSites(); err != nil {
+		t.Errorf("threshold below -total-timeout rejected: %v", err)
+	}
+
+	wm.TotalTimeout = 5 * time.Second
+	if err := wm.ValidateSites(); err == nil {
+		t.Error("threshold above -total-timeout accepted")
+	}
+	if err := wm.AddSite(SiteConfig{URL: "https://example.org", SlowThresholdMs: 8000}); err == nil {
+		t.Error("AddSite accepted a threshold above -total-timeout")
+	}
+}
+
+func TestWarmupTimeoutUsesEffectiveTimeout(t *testing.T) {
+	site := SiteConfig{URL: "https://example.com", WarmupTimeout: Duration(8 * time.Second)}
+	if err := validateSite(&site); err != nil {
+		t.Fatalf("validateSite rejected a warmup timeout without a site timeout: %v", err)
+	}
+
+	wm := NewWebsiteMonitor([]SiteConfig{site})
+	wm.TotalTimeout = 5 * time.Second
+	if err := wm.ValidateSites(); err != nil {
+		t.Errorf("warmup timeout above -total-timeout rejected: %v", err)
+	}
+
+	wm.TotalTimeout = 10 * time.Second
+	if err := wm.ValidateSites(); err == nil {
+		t.Error("warmup timeout below -total-timeout accepted")
+	}
+}
+
+func TestResumeChecksEverySiteImmediately(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	wm := NewWebsiteMonitor([]SiteConfig{
+		{URL: "stub://a", CheckType: "stub"},
+		{URL: "stub://b", CheckType: "stub"},
+		{URL: "stub://c", CheckType: "stub"},
+	})
+	wm.Clock = clock
+	wm.Interval = time.Minute
+	wm.Schedule = ScheduleSpread
+	wm.RegisterChecker("stub", checkerFunc(func(ctx context.Context, site SiteConfig) PingResult {
+		return successResult(time.Millisecond)
+	}))
+	results := make(chan string, 10)
+	wm.OnResult(func(site string, result PingResult) { results <- site })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wm.StartMonitoring(ctx)
+
+	// The spread cycle checks one site, then waits for the clock
+	<-results
+	wm.Pause()
+	wm.Resume()
+
+	checked := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for len(checked) < 3 {
+		select {
+		case site := <-results:
+			checked[site] = true
+		case <-timeout:
+			t.Fatalf("resume checked %v, want every site without waiting for spread slots", checked)
+		}
+	}
+}
+
+func TestSpreadCycleIsNotAnOverrun(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	wm := NewWebsiteMonitor([]SiteConfig{
+		{URL: "stub://a", CheckType: "stub"},
+		{URL: "stub://b", CheckType: "stub"},
+		{URL: "stub://c", CheckType: "stub"},
+		{URL: "stub://d", CheckType: "stub"},
+	})
+	wm.Clock = clock
+	wm.Interval = time.Minute
+	wm.Schedule = ScheduleSpread
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	wm.RegisterChecker("stub", checkerFunc(func(ctx context.Context, site SiteConfig) PingResult {
+		started <- site.URL
+		if site.URL == "stub://d" {
+			<-release
+		}
+		return successResult(time.Millisecond)
+	}))
+	recorded := make(chan string, 10)
+	wm.OnResult(func(site string, result PingResult) { recorded <- site })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wm.StartMonitoring(ctx)
+
+	// Each site gets a 15s slot; let the dispatch loop reach its next wait
+	// before moving the clock on
+	for range 3 {
+		<-started
+		time.Sleep(20 * time.Millisecond)
+		clock.Advance(15 * time.Second)
+	}
+	if site := <-started; site != "stub://d" {
+		t.Fatalf("last site dispatched was %s, want stub://d", site)
+	}
+
+	// The last check outlasts its slot, so the cycle runs past the next
+	// tick without its checks taking longer than the interval
+	clock.Advance(20 * time.Second)
+	close(release)
+	for site := range recorded {
+		if site == "stub://d" {
+			break
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for wm.Stats().LastCycleMs == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := wm.Stats()
+	if stats.LastCycleMs != 65000 {
+		t.Fatalf("last cycle took %d ms, want 65000", stats.LastCycleMs)
+	}
+	if stats.CycleOverruns != 0 {
+		t.Errorf("spread cycle counted as %d overrun(s), want 0", stats.CycleOverruns)
+	}
+}
+
+func TestOverlappingCyclesStayCounted(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	wm := NewWebsiteMonitor([]SiteConfig{
+		{URL: "stub://a", CheckType: "stub"},
+		{URL: "stub://b", CheckType: "stub"},
+	})
+	wm.Clock = clock
+	wm.Interval = time.Minute
+	wm.Schedule = ScheduleSpread
+	wm.RegisterChecker("stub", checkerFunc(func(ctx context.Context, site SiteConfig) PingResult {
+		return successResult(time.Millisecond)
+	}))
+	results := make(chan string, 10)
+	wm.OnResult(func(site string, result PingResult) { results <- site })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wm.StartMonitoring(ctx)
+
+	// The spread cycle is still waiting to dispatch b when an unspread one
+	// runs and finishes
+	<-results
+	<-wm.runCycle(nil, false)
+
+	wm.mu.RLock()
+	running := wm.cyclesRunning
+	wm.mu.RUnlock()
+	if running != 1 {
+		t.Errorf("%d cycle(s) counted as running, want the spread cycle still counted", running)
+	}
+}
+
+func TestResumeAfterShutdownStartsNoChecks(t *testing.T) {
+	var checks atomic.Int32
+	wm := NewWebsiteMonitor([]SiteConfig{{URL: "stub://a", CheckType: "stub"}})
+	wm.Clock = NewFakeClock(epoch)
+	wm.Interval = time.Minute
+	wm.RegisterChecker("stub", checkerFunc(func(ctx context.Context, site SiteConfig) PingResult {
+		checks.Add(1)
+		return successResult(time.Millisecond)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wm.StartMonitoring(ctx)
+	if err := wm.WaitInitial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	wm.Shutdown(context.Background())
+
+	wm.Pause()
+	wm.Resume()
+	time.Sleep(50 * time.Millisecond)
+	if got := checks.Load(); got != 1 {
+		t.Errorf("%d check(s) ran, want only the one before shutdown", got)
+	}
+}
+
+func TestResultHooksRunInOrderDespitePanics(t *testing.T) {
+	wm := NewWebsiteMonitor([]SiteConfig{{URL: "https://example.com"}})
+	wm.OnResult(func(site string, result PingResult) { panic("broken hook") })
+	seen := make(chan time.Time, 100)
+	wm.OnResult(func(site string, result PingResult) { seen <- result.CheckedAt })
+
+	for i := range 100 {
+		wm.recordResult(wm.Sites()[0], PingResult{Status: "success", CheckedAt: epoch.Add(time.Duration(i) * time.Second)})
+	}
+
+	for i := range 100 {
+		select {
+		case got := <-seen:
+			if want := epoch.Add(time.Duration(i) * time.Second); !got.Equal(want) {
+				t.Fatalf("result %d delivered checked at %s, want %s", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of 100 results reached the hook after a panicking one", i)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package monitor
+
+import "time"
+
+// Overall status levels for dashboards
+const (
+	OverallGreen  = "green"
+	OverallYellow = "yellow"
+	OverallRed    = "red"
+)
+
+// OverallThresholds configures how site results roll up into a single
+// traffic-light status
+type OverallThresholds struct {
+	// RedFailures is how many sites must be down for red; fewer down
+	// sites give yellow. Values below 1 are treated as 1.
+	RedFailures int
+
+	// SlowLatency marks a site degraded when its latency exceeds it; 0
+	// disables the latency check
+	SlowLatency time.Duration
+}
+
+// overallStatus rolls results up into green, yellow or red. A site is down
+// when its check failed outright, and degraded when it is slow, burning its
+// error budget, breaching a latency SLA or failing only some of its paths.
+// Sites in maintenance or whose check was cancelled are ignored.
+func overallStatus(results map[string]PingResult, t OverallThresholds) string {
+	redFailures := max(t.RedFailures, 1)
+
+	down, degraded := 0, 0
+	for _, result := range results {
+		switch {
+		case result.Self:
+		case result.Status == "failed" && partiallyFailed(result):
+			degraded++
+		case result.Status == "failed":
+			down++
+		case result.Status == "slow":
+			degraded++
+		case result.Status != "success":
+		case result.BurnRate != nil && result.BurnRate.Alerting:
+			degraded++
+		case slaBreached(result):
+			degraded++
+		case t.SlowLatency > 0 && result.Duration > t.SlowLatency:
+			degraded++
+		}
+	}
+
+	switch {
+	case down >= redFailures:
+		return OverallRed
+	case down > 0 || degraded > 0:
+		return OverallYellow
+	default:
+		return OverallGreen
+	}
+}
+
+// slaBreached reports whether the result breaches any latency SLA
+func slaBreached(result PingResult) bool {
+	for _, status := range result.LatencySLA {
+		if status.Breached {
+			return true
+		}
+	}
+	return false
+}
+
+// partiallyFailed reports whether a multi-path result has at least one
+// path still succeeding
+func partiallyFailed(result PingResult) bool {
+	for _, path := range result.Paths {
+		if path.Status == "success" {
+			return true
+		}
+	}
+	return false
+}
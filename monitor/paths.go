@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// checkPaths checks each of a site's paths in turn. Running them one after
+// another on the same client lets every path reuse the host's connection.
+// The site's result summarizes its paths, with loss being the share of
+// failed paths.
+func checkPaths(ctx context.Context, checker Checker, site SiteConfig) PingResult {
+	base, err := normalizeURL(site)
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Invalid URL: %v", err))
+	}
+
+	results := make(map[string]PingResult, len(site.Paths))
+	var failed, timed int
+	var total time.Duration
+	var changed bool
+
+	for _, path := range site.Paths {
+		sub := site
+		sub.Paths = nil
+		sub.URL, err = withPath(base, path)
+		if err != nil {
+			results[path] = deterministicFailure(fmt.Sprintf("Invalid path: %v", err))
+			failed++
+			continue
+		}
+
+		result := runCheck(ctx, checker, sub)
+		results[path] = result
+
+		if result.Status != "success" {
+			failed++
+		}
+		changed = changed || result.ContentChanged
+		if result.Duration > 0 {
+			total += result.Duration
+			timed++
+		}
+	}
+
+	summary := PingResult{
+		Status:         "success",
+		ContentChanged: changed,
+		Loss:           fmt.Sprintf("%.0f%%", float64(failed)/float64(len(site.Paths))*100),
+		Paths:          results,
+	}
+	if failed > 0 {
+		summary.Status = "failed"
+		summary.Error = fmt.Sprintf("%d of %d paths failed", failed, len(site.Paths))
+	}
+	if timed > 0 {
+		summary.Duration = total / time.Duration(timed)
+		summary.AvgTime = formatDuration(summary.Duration)
+	}
+
+	return summary
+}
+
+// withPath replaces the path and query of base with the given path
+func withPath(base, path string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse("/" + strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return "", err
+	}
+	u.Path = ref.Path
+	u.RawPath = ref.RawPath
+	u.RawQuery = ref.RawQuery
+
+	return u.String(), nil
+}
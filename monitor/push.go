@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Pusher POSTs result snapshots to a collector, for deployments where the
+// monitor can't be scraped
+type Pusher struct {
+	URL    string
+	Client *http.Client
+
+	// Group names the monitor group in the X-Monitor-Group header
+	Group string
+
+	// HistorySamples is how many of each site's most recent samples are
+	// included with the results, letting the collector follow trends; 0
+	// sends only the latest results
+	HistorySamples int
+
+	// Attempts is how many times each snapshot is tried, with Backoff
+	// doubling between attempts
+	Attempts int
+	Backoff  time.Duration
+}
+
+// Push sends the snapshot, retrying failures. Snapshots that can't be
+// delivered are logged and dropped; the next cycle sends a fresh one.
+func (p *Pusher) Push(snapshot pingResponse) {
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("Failed to encode push snapshot: %v", err)
+		return
+	}
+
+	attempts := max(p.Attempts, 1)
+	for attempt := 1; ; attempt++ {
+		err = p.send(body)
+		if err == nil {
+			return
+		}
+		if attempt == attempts {
+			break
+		}
+		time.Sleep(jitter(p.Backoff << (attempt - 1)))
+	}
+	log.Printf("Failed to push results to %s after %d attempt(s): %v", p.URL, attempts, err)
+}
+
+// send makes a single delivery attempt
+func (p *Pusher) send(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Group != "" {
+		req.Header.Set("X-Monitor-Group", p.Group)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// snapshot returns the current results ordered by site, with their
+// overall status
+func (wm *WebsiteMonitor) snapshot() pingResponse {
+	results := wm.GetResults()
+	list, _ := sortResults(results, "site")
+	return pingResponse{Overall: overallStatus(results, wm.Overall), Results: list}
+}
+
+// recentHistory returns up to n of each site's most recent samples
+func (wm *WebsiteMonitor) recentHistory(n int) map[string][]Sample {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	out := make(map[string][]Sample, len(wm.history))
+	for site, h := range wm.history {
+		out[site] = h.status.recent(n)
+	}
+	return out
+}
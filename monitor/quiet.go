@@ -0,0 +1,140 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuietHours is an Alerter that holds back less urgent alerts during a
+// daily window, such as overnight, and delivers them as a single digest
+// when the window ends. Alerts at least as urgent as Urgent are always sent
+// straight away.
+type QuietHours struct {
+	next Alerter
+
+	// Urgent is the least urgent severity sent during quiet hours;
+	// empty means critical
+	Urgent string
+
+	// Clock tells the time of day; nil uses the real clock
+	Clock Clock
+
+	// start and end are minutes since midnight in loc; a window with
+	// start after end spans midnight
+	start, end int
+	loc        *time.Location
+
+	mu      sync.Mutex
+	pending []Alert
+	timer   Timer
+}
+
+// NewQuietHours holds less urgent alerts for next during the window spec,
+// written as "HH:MM-HH:MM" in loc, e.g. "22:00-07:00"
+func NewQuietHours(next Alerter, spec string, loc *time.Location) (*QuietHours, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("quiet hours must look like 22:00-07:00, got %q", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("quiet hours %q start and end at the same time", spec)
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &QuietHours{next: next, start: start, end: end, loc: loc}, nil
+}
+
+// parseClock parses an HH:MM time of day into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// window reports whether t falls in quiet hours and, if so, when they end
+func (q *QuietHours) window(t time.Time) (bool, time.Time) {
+	t = t.In(q.loc)
+	now := t.Hour()*60 + t.Minute()
+	endsAt := time.Date(t.Year(), t.Month(), t.Day(), q.end/60, q.end%60, 0, 0, q.loc)
+
+	if q.start < q.end {
+		return now >= q.start && now < q.end, endsAt
+	}
+	if now >= q.start {
+		return true, endsAt.AddDate(0, 0, 1)
+	}
+	return now < q.end, endsAt
+}
+
+// Send forwards urgent alerts and those outside quiet hours, holding the
+// rest until quiet hours end
+func (q *QuietHours) Send(ctx context.Context, alert Alert) error {
+	urgent := q.Urgent
+	if urgent == "" {
+		urgent = SeverityCritical
+	}
+	clock := q.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	now := clock.Now()
+	quiet, endsAt := q.window(now)
+	if !quiet || severityRank(alert.Severity) <= severityRank(urgent) {
+		return q.next.Send(ctx, alert)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, alert)
+	if q.timer == nil {
+		q.timer = clock.AfterFunc(endsAt.Sub(now), q.flush)
+	}
+	return nil
+}
+
+// Close sends any held alerts straight away
+func (q *QuietHours) Close() {
+	q.mu.Lock()
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	q.mu.Unlock()
+	q.flush()
+}
+
+// flush delivers the alerts held during quiet hours as one digest
+func (q *QuietHours) flush() {
+	q.mu.Lock()
+	pending := q.pending
+	q.pending, q.timer = nil, nil
+	q.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	digest := batchAlert(pending)
+	digest.Event = "digest"
+	digest.Message = "Held during quiet hours: " + digest.Message
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := q.next.Send(ctx, digest); err != nil {
+		log.Printf("Failed to deliver quiet hours digest: %v", err)
+	}
+}
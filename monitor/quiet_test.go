@@ -0,0 +1,57 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingAlerter keeps the alerts it is sent
+type recordingAlerter struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recordingAlerter) Send(ctx context.Context, alert Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func (r *recordingAlerter) sent() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Alert(nil), r.alerts...)
+}
+
+func TestQuietHoursHoldsUntilWindowEnds(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC))
+	next := &recordingAlerter{}
+	quiet, err := NewQuietHours(next, "22:00-07:00", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet.Clock = clock
+
+	ctx := context.Background()
+	quiet.Send(ctx, Alert{Site: "a", Event: "content_changed", Severity: SeverityWarning})
+	quiet.Send(ctx, Alert{Site: "b", Event: "down", Severity: SeverityCritical})
+
+	sent := next.sent()
+	if len(sent) != 1 || sent[0].Site != "b" {
+		t.Fatalf("sent during quiet hours: %+v, want only the critical alert", sent)
+	}
+
+	clock.Advance(7*time.Hour + 59*time.Minute)
+	if got := len(next.sent()); got != 1 {
+		t.Fatalf("%d alerts sent before quiet hours ended, want 1", got)
+	}
+
+	clock.Advance(time.Minute)
+	sent = next.sent()
+	if len(sent) != 2 || sent[1].Event != "digest" {
+		t.Fatalf("after quiet hours got %+v, want a digest", sent)
+	}
+}
@@ -0,0 +1,82 @@
+package monitor
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultPorts are the ports implied by URL schemes without one
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ws": "80", "wss": "443"}
+
+// targetsSelf reports whether site targets one of the monitor's own listen
+// addresses, such as its /healthz on localhost. A site counts when it uses
+// a listening port and its host is loopback, this machine's hostname or the
+// listener's own host.
+func targetsSelf(site SiteConfig, listen []string) bool {
+	host, port := siteHostPort(site)
+	if host == "" || port == "" {
+		return false
+	}
+
+	for _, addr := range listen {
+		listenHost, listenPort, err := net.SplitHostPort(addr)
+		if err != nil || listenPort != port {
+			continue
+		}
+		if localHost(host) || strings.EqualFold(host, listenHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSelf reports whether site points back at the monitor
+func (wm *WebsiteMonitor) isSelf(site SiteConfig) bool {
+	return len(wm.SelfAddrs) > 0 && targetsSelf(site, wm.SelfAddrs)
+}
+
+// warnIfSelf logs a warning when site points back at the monitor, whose
+// results say little about its availability to anyone else
+func (wm *WebsiteMonitor) warnIfSelf(site SiteConfig) {
+	if !wm.isSelf(site) {
+		return
+	}
+	alerting := "without alerts"
+	if wm.SelfAlerts {
+		alerting = "with alerts"
+	}
+	log.Printf("WARNING: %s points back at this monitor; checking it as a self-check %s and leaving it out of the overall status", site.URL, alerting)
+}
+
+// siteHostPort returns the host and port a site's checks connect to
+func siteHostPort(site SiteConfig) (string, string) {
+	var host, port string
+	if u, err := url.Parse(site.URL); err == nil && u.Host != "" {
+		host, port = u.Hostname(), u.Port()
+		if port == "" {
+			port = defaultPorts[u.Scheme]
+		}
+	} else if h, p, err := net.SplitHostPort(site.URL); err == nil {
+		host, port = h, p
+	}
+	if site.Port > 0 {
+		port = strconv.Itoa(site.Port)
+	}
+	return host, port
+}
+
+// localHost reports whether host names this machine
+func localHost(host string) bool {
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || ip.IsUnspecified()
+	}
+	name, err := os.Hostname()
+	return err == nil && strings.EqualFold(host, name)
+}
@@ -0,0 +1,99 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSelfTestURL is a reliable endpoint used to confirm the monitor
+// itself has outbound connectivity
+const DefaultSelfTestURL = "https://www.google.com/generate_204"
+
+// SelfTest verifies outbound connectivity so a broken monitor network
+// isn't mistaken for every site being down
+type SelfTest struct {
+	URL     string
+	Timeout time.Duration
+	Checker Checker
+
+	// Interval re-runs the self-test after Start, so a failure at boot
+	// clears once the network recovers; 0 runs it only once
+	Interval time.Duration
+
+	mu     sync.RWMutex
+	result *PingResult
+}
+
+// Start runs the self-test, then keeps re-running it every Interval until
+// ctx is cancelled
+func (s *SelfTest) Start(ctx context.Context) {
+	s.Run(ctx)
+	if s.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run checks the self-test URL, logs the outcome and keeps it for Result.
+// Repeat runs are only logged when the outcome changes.
+func (s *SelfTest) Run(ctx context.Context) PingResult {
+	result := runCheck(ctx, s.Checker, SiteConfig{URL: s.URL, Timeout: Duration(s.Timeout)})
+	result.CheckedAt = time.Now().UTC()
+	if ctx.Err() != nil {
+		return result
+	}
+
+	s.mu.Lock()
+	previous := s.result
+	s.result = &result
+	s.mu.Unlock()
+
+	passed := result.Status == "success"
+	if previous != nil && (previous.Status == "success") == passed {
+		return result
+	}
+	if passed {
+		log.Printf("Self-test passed: reached %s in %s", s.URL, result.AvgTime)
+	} else {
+		log.Printf("Self-test FAILED: cannot reach %s: %s. Site failures may reflect the monitor's own network.", s.URL, result.Error)
+	}
+	return result
+}
+
+// Result returns the latest self-test result, or nil before the first run
+func (s *SelfTest) Result() *PingResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.result
+}
+
+// HealthzHandler reports liveness, failing with 503 while the latest
+// self-test couldn't reach the network
+func HealthzHandler(selfTest *SelfTest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if selfTest != nil {
+			if result := selfTest.Result(); result != nil && result.Status != "success" {
+				WriteError(w, http.StatusServiceUnavailable, "self-test failed: "+result.Error)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, "ok")
+	}
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthzRecoversAfterSelfTestFailure(t *testing.T) {
+	var up atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	selfTest := &SelfTest{URL: srv.URL, Checker: &HTTPChecker{}}
+	healthz := HealthzHandler(selfTest)
+	selfTest.Run(context.Background())
+
+	rec := httptest.NewRecorder()
+	healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d after a failed self-test, want 503", rec.Code)
+	}
+	var body apiError
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Code != http.StatusServiceUnavailable {
+		t.Errorf("body is not a JSON error: %+v, %v", body, err)
+	}
+
+	up.Store(true)
+	selfTest.Run(context.Background())
+
+	rec = httptest.NewRecorder()
+	healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d after the self-test recovered, want 200", rec.Code)
+	}
+}
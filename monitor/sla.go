@@ -0,0 +1,89 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// LatencySLA is a percentile latency objective over a rolling window, such
+// as p95 over the last hour staying within 500ms
+type LatencySLA struct {
+	Percentile float64  `json:"percentile"`
+	Window     Duration `json:"window"`
+	MaxMS      float64  `json:"max_ms"`
+}
+
+// SLAStatus reports how a site is doing against one latency SLA
+type SLAStatus struct {
+	Percentile float64 `json:"percentile"`
+	Window     string  `json:"window"`
+	MaxMS      float64 `json:"max_ms"`
+	ObservedMS float64 `json:"observed_ms"`
+	Breached   bool    `json:"breached"`
+}
+
+// Validate checks the rule is usable
+func (r LatencySLA) Validate() error {
+	if r.Percentile <= 0 || r.Percentile > 100 {
+		return fmt.Errorf("percentile must be between 0 and 100, got %v", r.Percentile)
+	}
+	if r.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if r.MaxMS <= 0 {
+		return fmt.Errorf("max_ms must be positive")
+	}
+	return nil
+}
+
+// evaluate computes the percentile latency of successful checks within the
+// rule's window. A window without any timed checks is never breached.
+func (r LatencySLA) evaluate(h *history, now time.Time) SLAStatus {
+	status := SLAStatus{
+		Percentile: r.Percentile,
+		Window:     r.Window.String(),
+		MaxMS:      r.MaxMS,
+	}
+
+	var durations []time.Duration
+	for _, s := range h.since(now.Add(-time.Duration(r.Window))) {
+		if hasLatency(s) {
+			durations = append(durations, s.Duration)
+		}
+	}
+	if len(durations) == 0 {
+		return status
+	}
+
+	status.ObservedMS = float64(percentile(durations, r.Percentile).Microseconds()) / 1000
+	status.Breached = status.ObservedMS > r.MaxMS
+	return status
+}
+
+// percentile returns the nearest-rank p-th percentile of durations
+func percentile(durations []time.Duration, p float64) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// slaAlert describes a site breaching or recovering from a latency SLA
+func slaAlert(site string, status SLAStatus, t time.Time) Alert {
+	alert := Alert{
+		Site:  site,
+		Event: "latency_sla",
+		Message: fmt.Sprintf("p%g latency over %s is %.0fms, above the %.0fms limit",
+			status.Percentile, status.Window, status.ObservedMS, status.MaxMS),
+		Time: t,
+	}
+	if !status.Breached {
+		alert.Event = "latency_sla_resolved"
+		alert.Message = fmt.Sprintf("p%g latency over %s back to %.0fms, within the %.0fms limit",
+			status.Percentile, status.Window, status.ObservedMS, status.MaxMS)
+	}
+	return alert
+}
@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+)
+
+// SLOConfig configures multi-window, multi-burn-rate alerting against an
+// availability objective. An alert fires only while both the short and the
+// long window burn faster than Threshold, so brief blips don't page but
+// sustained budget consumption does.
+type SLOConfig struct {
+	Target      float64
+	ShortWindow time.Duration
+	LongWindow  time.Duration
+	Threshold   float64
+}
+
+// BurnRate reports how fast a site is consuming its error budget, where
+// 1 means exactly exhausting the budget over the SLO period
+type BurnRate struct {
+	Short    float64 `json:"short"`
+	Long     float64 `json:"long"`
+	Alerting bool    `json:"alerting"`
+}
+
+// Validate checks the configuration is usable
+func (c SLOConfig) Validate() error {
+	if c.Target <= 0 || c.Target >= 1 {
+		return fmt.Errorf("target must be between 0 and 1, got %v", c.Target)
+	}
+	if c.ShortWindow <= 0 || c.LongWindow < c.ShortWindow {
+		return fmt.Errorf("windows must be positive with the long window at least the short one")
+	}
+	if c.Threshold <= 0 {
+		return fmt.Errorf("threshold must be positive")
+	}
+	return nil
+}
+
+// evaluate computes a site's burn rates from its history
+func (c SLOConfig) evaluate(h *history, now time.Time) *BurnRate {
+	br := &BurnRate{
+		Short: burnRate(h.since(now.Add(-c.ShortWindow)), c.Target),
+		Long:  burnRate(h.since(now.Add(-c.LongWindow)), c.Target),
+	}
+	br.Alerting = br.Short > c.Threshold && br.Long > c.Threshold
+	return br
+}
+
+// burnRate is the observed error rate relative to the error budget
+func burnRate(samples []Sample, target float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	failed := 0
+	for _, s := range samples {
+		if !healthy(s.Status) {
+			failed++
+		}
+	}
+
+	return float64(failed) / float64(len(samples)) / (1 - target)
+}
+
+// burnRateAlert describes a site starting or stopping burning its budget
+func burnRateAlert(site string, result PingResult) Alert {
+	alert := Alert{
+		Site:  site,
+		Event: "burn_rate",
+		Message: fmt.Sprintf("Error budget burning at %.1fx (short window) and %.1fx (long window)",
+			result.BurnRate.Short, result.BurnRate.Long),
+		Time: result.CheckedAt,
+	}
+	if !result.BurnRate.Alerting {
+		alert.Event = "burn_rate_resolved"
+		alert.Message = fmt.Sprintf("Error budget burn rate back to %.1fx (short window)", result.BurnRate.Short)
+	}
+	return alert
+}
@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Exporter receives a snapshot of all results after each check cycle
+type Exporter interface {
+	Export(snapshot pingResponse)
+}
+
+// statsdPacketSize keeps datagrams under a typical MTU
+const statsdPacketSize = 1432
+
+// StatsD exports results over UDP as site.up gauges and site.response_ms
+// timers. By default each metric carries DogStatsD site and group tags;
+// with Tags off the site is folded into the metric name instead for
+// servers that don't understand tags.
+type StatsD struct {
+	Addr   string
+	Prefix string
+	Group  string
+	Tags   bool
+
+	once sync.Once
+	conn net.Conn
+	err  error
+}
+
+// Export sends one gauge and, for timed checks, one timer per site
+func (s *StatsD) Export(snapshot pingResponse) {
+	s.once.Do(func() { s.conn, s.err = net.Dial("udp", s.Addr) })
+	if s.err != nil {
+		log.Printf("StatsD export to %s disabled: %v", s.Addr, s.err)
+		return
+	}
+
+	var lines []string
+	for _, sr := range snapshot.Results {
+		var up int
+		switch sr.Result.Status {
+		case "success", "slow":
+			up = 1
+		case "failed":
+			up = 0
+		default:
+			continue
+		}
+		lines = append(lines, s.line("site.up", sr.Site, fmt.Sprintf("%d|g", up)))
+		if sr.Result.Duration > 0 {
+			ms := float64(sr.Result.Duration.Microseconds()) / 1000
+			lines = append(lines, s.line("site.response_ms", sr.Site, fmt.Sprintf("%g|ms", ms)))
+		}
+	}
+
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			s.send(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		s.send(packet.Bytes())
+	}
+}
+
+// line formats a metric for site
+func (s *StatsD) line(name, site, value string) string {
+	if !s.Tags {
+		return fmt.Sprintf("%s%s.%s:%s", s.Prefix, name, statsdName(site), value)
+	}
+	tags := "site:" + statsdTag(site)
+	if s.Group != "" {
+		tags += ",group:" + statsdTag(s.Group)
+	}
+	return fmt.Sprintf("%s%s:%s|#%s", s.Prefix, name, value, tags)
+}
+
+// send writes a datagram, logging failures
+func (s *StatsD) send(packet []byte) {
+	if _, err := s.conn.Write(packet); err != nil {
+		log.Printf("Failed to send metrics to StatsD at %s: %v", s.Addr, err)
+	}
+}
+
+// statsdName makes a site usable as a metric name segment
+var statsdName = strings.NewReplacer(
+	"://", "_", ".", "_", ":", "_", "/", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_",
+).Replace
+
+// statsdTag strips the characters DogStatsD reserves from a tag value
+var statsdTag = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace
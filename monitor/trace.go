@@ -0,0 +1,86 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// requestTrace collects connection details of a request through httptrace.
+// With redirects, the details describe the last connection used.
+type requestTrace struct {
+	mu        sync.Mutex
+	servedBy  string
+	reused    bool
+	firstByte time.Time
+}
+
+// clientTrace returns the hooks that feed this trace
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			// A reused connection still reports the peer it was opened to
+			t.servedBy = remoteIP(info.Conn.RemoteAddr())
+			t.reused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.firstByte = time.Now()
+		},
+	}
+}
+
+// apply copies the collected details into result, with time to first byte
+// measured from start
+func (t *requestTrace) apply(result *PingResult, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result.ServedBy = t.servedBy
+	result.ConnReused = t.reused
+	if !t.firstByte.IsZero() {
+		result.TTFBMs = float64(t.firstByte.Sub(start).Microseconds()) / 1000
+	}
+}
+
+// remoteIP extracts the IP address from a connection's remote address
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// newTraceID returns a random W3C trace ID, used as the request ID of a check
+func newTraceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// setTraceHeaders identifies a request to the checked endpoint with
+// X-Request-ID and a W3C traceparent carrying traceID and a fresh span.
+// Headers the site sets itself are left alone.
+func setTraceHeaders(req *http.Request, traceID string) {
+	if req.Header.Get("X-Request-ID") == "" {
+		req.Header.Set("X-Request-ID", traceID)
+	}
+	if req.Header.Get("traceparent") == "" {
+		span := make([]byte, 8)
+		rand.Read(span)
+		req.Header.Set("traceparent", fmt.Sprintf("00-%s-%x-01", traceID, span))
+	}
+}
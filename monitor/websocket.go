@@ -0,0 +1,207 @@
+package monitor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// websocketGUID is the fixed value servers hash with the client key (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes
+const (
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+// WebSocketChecker checks that a ws:// or wss:// endpoint accepts the
+// upgrade handshake and, optionally, answers a ping
+type WebSocketChecker struct {
+	Client *http.Client
+
+	once     sync.Once
+	fallback *http.Client
+}
+
+// Check performs the opening handshake and records its latency
+func (c *WebSocketChecker) Check(ctx context.Context, site SiteConfig) PingResult {
+	u, err := url.Parse(site.URL)
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Invalid URL: %v", err))
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return deterministicFailure(fmt.Sprintf("Unsupported websocket scheme %q", u.Scheme))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return deterministicFailure(fmt.Sprintf("Failed to create request: %v", err))
+	}
+
+	setHeaders(req, site.Headers)
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	start := time.Now()
+	resp, err := c.client().Do(req)
+	duration := time.Since(start)
+
+	if err != nil {
+		return retryableFailure(fmt.Sprintf("Handshake failed: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		result := deterministicFailure(fmt.Sprintf("Upgrade rejected: %s", resp.Status))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			result.FailureKind = FailureRetryable
+		}
+		result.StatusCode = resp.StatusCode
+		return result
+	}
+
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return deterministicFailure("Upgrade failed: invalid Sec-WebSocket-Accept")
+	}
+
+	result := successResult(duration)
+	result.StatusCode = resp.StatusCode
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return deterministicFailure("Upgrade failed: connection not writable")
+	}
+
+	// Unblock any pending read if the check times out
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if site.WebSocketPing {
+		if err := websocketPing(conn); err != nil {
+			failed := retryableFailure(fmt.Sprintf("Ping failed: %v", err))
+			failed.StatusCode = resp.StatusCode
+			return failed
+		}
+	}
+
+	writeFrame(conn, wsOpClose, nil)
+
+	return result
+}
+
+// client returns the configured client or a default HTTP/1.1-only one
+func (c *WebSocketChecker) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+
+	// Upgrades only work over HTTP/1.1, so never negotiate HTTP/2
+	c.once.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		c.fallback = &http.Client{Transport: transport}
+	})
+	return c.fallback
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept expected for key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// websocketPing sends a ping and waits for the matching pong, skipping any
+// data frames the server sends first
+func websocketPing(conn io.ReadWriter) error {
+	if err := writeFrame(conn, wsOpPing, []byte("monitor")); err != nil {
+		return err
+	}
+
+	for {
+		op, err := readFrame(conn)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case wsOpPong:
+			return nil
+		case wsOpClose:
+			return fmt.Errorf("connection closed before pong")
+		}
+	}
+}
+
+// writeFrame writes a single masked client frame
+func writeFrame(w io.Writer, op byte, payload []byte) error {
+	if len(payload) > 125 {
+		return fmt.Errorf("control frame payload too large")
+	}
+
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads one frame, discarding its payload, and returns its opcode
+func readFrame(r io.Reader) (byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if header[1]&0x80 != 0 {
+		length += 4 // masking key
+	}
+
+	if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+		return 0, err
+	}
+
+	return header[0] & 0x0f, nil
+}